@@ -2,7 +2,7 @@
 package api
 
 import (
-	"crypto/subtle"
+	"../auth"
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
 	"net/http"
@@ -12,8 +12,8 @@ var (
 	log = logging.MustGetLogger("api")
 )
 
-func Init(authKey string) {
-	runServer(authKey)
+func Init() {
+	runServer()
 }
 
 // Recover panics
@@ -36,47 +36,107 @@ func Errors(c *gin.Context) {
 	}
 }
 
-// Auth requests
+// Auth requests. Clients send the key from the auth file or a named api
+// token on every request as the Auth-Key header with User-Agent "pritunl"
+// and no Origin or Referer. After a rotation clients must re-read the auth
+// file. Unix socket callers are already authorized by peer credentials.
 func Auth(c *gin.Context) {
+	if peer := getPeer(c); peer != nil {
+		c.Set("scopes", peer.Scopes())
+		c.Next()
+		return
+	}
+
 	if c.Request.Header.Get("Origin") != "" ||
 		c.Request.Header.Get("Referer") != "" ||
-		c.Request.Header.Get("User-Agent") != "pritunl" ||
-		subtle.ConstantTimeCompare(
-			[]byte(c.Request.Header.Get("Auth-Key")),
-			[]byte(Key)) != 1 {
+		c.Request.Header.Get("User-Agent") != "pritunl" {
 
 		c.AbortWithStatus(401)
 		return
 	}
+
+	scopes, ok := auth.GetScopes(c.Request.Header.Get("Auth-Key"))
+	if !ok {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	c.Set("scopes", scopes)
 	c.Next()
 }
 
+// Require scope for route
+func Scope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.HasScope(c.GetStringSlice("scopes"), scope) {
+			c.AbortWithStatus(403)
+			return
+		}
+		c.Next()
+	}
+}
+
 func Register(engine *gin.Engine) {
-	// engine.Use(Auth)
 	engine.Use(Recovery)
 	engine.Use(Errors)
 
+	// пингуем хелпер, доступен без ключа
+	engine.GET("/ping", pingGet)
+
+	engine.Use(Auth)
+
+	read := Scope(auth.ScopeRead)
+	control := Scope(auth.ScopeControl)
+	admin := Scope(auth.ScopeAdmin)
+
+	// перевыпуск ключа авторизации
+	engine.POST("/auth/rotate", admin, authRotatePost)
+	// управление api токенами
+	engine.GET("/auth/tokens", admin, authTokensGet)
+	engine.POST("/auth/tokens", admin, authTokensPost)
+	engine.DELETE("/auth/tokens/:name", admin, authTokensDel)
+
 	// ???
-	engine.GET("/events", eventsGet)
-	// получить текущий профиль
-	engine.GET("/profile", profileGet)
-	// добавление профиля
-	engine.POST("/profile", profilePost)
+	engine.GET("/events", read, eventsGet)
+	// получить сохраненные и активные профили
+	engine.GET("/profile", read, profileGet)
+	// подключение профиля, сохраненного по id или переданного целиком
+	engine.POST("/profile", control, profilePost)
 	// todo убрать метод удаления профилей
-	engine.DELETE("/profile", profileDel)
+	engine.DELETE("/profile", control, profileDel)
+	// проверка профиля без подключения
+	engine.POST("/profile/validate", read, validatePost)
+	// импорт профиля с внешними файлами или pkcs12
+	engine.POST("/profile/import", control, importPost)
+	// сохраненные профили
+	engine.GET("/profile/:id", read, storedGet)
+	engine.PUT("/profile/:id", control, storedPut)
+	engine.DELETE("/profile/:id", control, storedDel)
+	// результаты проверки задержки до серверов
+	engine.GET("/profile/:id/remotes", read, remotesGet)
+	// маршруты установленные для подключенного профиля
+	engine.GET("/profile/:id/routes", read, routesGet)
+	// сертификаты встроенные в профиль
+	engine.GET("/profile/:id/certificates", read, certificatesGet)
+	// ответ на запрос одноразового кода
+	engine.POST("/profile/:id/challenge", control, challengePost)
+	// ответ на запрос учетных данных
+	engine.POST("/profile/:id/credentials", control, credentialsPost)
+	// забыть сохраненные учетные данные
+	engine.DELETE("/profile/:id/credentials", control, credentialsDel)
 
 	// todo с токеном выпилить логику. мы используем один профиль
-	engine.PUT("/token", tokenPut)
-	engine.DELETE("/token", tokenDelete)
+	engine.PUT("/token", control, tokenPut)
+	engine.DELETE("/token", control, tokenDelete)
 
-	// пингуем хелпер
-	engine.GET("/ping", pingGet)
 	// остановить текущее соединение
-	engine.POST("/stop", stopPost)
+	engine.POST("/stop", control, stopPost)
 	// переподключение по профилю
-	engine.POST("/restart", restartPost)
+	engine.POST("/restart", control, restartPost)
 	// текущий статус соединия
-	engine.GET("/status", statusGet)
+	engine.GET("/status", read, statusGet)
+	// установленный openvpn, версия и возможности
+	engine.GET("/system/openvpn", read, openvpnGet)
 	// поднимаем соединение
-	engine.POST("/wakeup", wakeupPost)
+	engine.POST("/wakeup", control, wakeupPost)
 }
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"../auth"
+	"github.com/gin-gonic/gin"
+)
+
+func rotateKey() (err error) {
+	_, err = auth.Rotate()
+	if err != nil {
+		return
+	}
+
+	log.Info("api: Auth key rotated")
+
+	return
+}
+
+func authRotatePost(c *gin.Context) {
+	err := rotateKey()
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
+
+type authTokenData struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key,omitempty"`
+}
+
+func authTokensGet(c *gin.Context) {
+	data := []*authTokenData{}
+
+	for _, tokn := range auth.GetTokens() {
+		data = append(data, &authTokenData{
+			Name:   tokn.Name,
+			Scopes: tokn.Scopes,
+		})
+	}
+
+	c.JSON(200, data)
+}
+
+func authTokensPost(c *gin.Context) {
+	data := &authTokenData{}
+	c.Bind(data)
+
+	tokn, key, err := auth.NewToken(data.Name, data.Scopes)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	c.JSON(200, &authTokenData{
+		Name:   tokn.Name,
+		Scopes: tokn.Scopes,
+		Key:    key,
+	})
+}
+
+func authTokensDel(c *gin.Context) {
+	err := auth.RemoveToken(c.Param("name"))
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
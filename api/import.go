@@ -0,0 +1,93 @@
+package api
+
+import (
+	"../profile"
+	"errors"
+	"github.com/gin-gonic/gin"
+)
+
+type importData struct {
+	Id             string            `json:"id"`
+	Name           string            `json:"name"`
+	Data           string            `json:"data"`
+	Files          map[string][]byte `json:"files"`
+	Pkcs12         []byte            `json:"pkcs12"`
+	Pkcs12Password string            `json:"pkcs12_password"`
+	Reconnect      bool              `json:"reconnect"`
+}
+
+type importErrorData struct {
+	Error   string                `json:"error"`
+	Missing []*profile.MissingRef `json:"missing"`
+}
+
+func importPost(c *gin.Context) {
+	data := &importData{}
+	c.Bind(data)
+
+	id := profile.FilterStr(data.Id)
+	if id == "" || data.Data == "" {
+		c.AbortWithError(400,
+			errors.New("api: Profile id and data required"))
+		return
+	}
+
+	prflData, err := profile.Import(data.Data,
+		profile.MapResolver(data.Files), data.Pkcs12Password)
+	if err != nil {
+		if e, ok := err.(*profile.ImportError); ok {
+			c.Error(err)
+			c.JSON(400, &importErrorData{
+				Error:   "missing_files",
+				Missing: e.Missing,
+			})
+			return
+		}
+		c.AbortWithError(400, err)
+		return
+	}
+
+	if len(data.Pkcs12) > 0 {
+		prflData, err = profile.ImportPkcs12(prflData, data.Pkcs12,
+			data.Pkcs12Password)
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+	}
+
+	if !checkPolicy(c, prflData) {
+		return
+	}
+
+	stored, err := profile.GetStored(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored == nil {
+		stored = &profile.StoredProfile{
+			Id: id,
+		}
+	}
+
+	stored.Name = data.Name
+	stored.Data = prflData
+	stored.Reconnect = data.Reconnect
+
+	err = profile.SaveStored(stored)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, &storedData{
+		Id:             stored.Id,
+		Name:           stored.Name,
+		Data:           stored.Data,
+		Reconnect:      stored.Reconnect,
+		HasCredentials: stored.HasCredentials(),
+		KeyEncrypted:   profile.KeyEncrypted(stored.Data),
+	})
+}
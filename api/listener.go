@@ -0,0 +1,192 @@
+package api
+
+import (
+	"../auth"
+	"context"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
+	"github.com/gin-gonic/gin"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHostApi = "127.0.0.1"
+)
+
+type peerKey struct{}
+
+// Credentials of process on other side of unix socket
+type Peer struct {
+	Uid int `json:"uid"`
+	Gid int `json:"gid"`
+}
+
+// Root gets admin, other allowed peers get scopes from serverSocketScopes
+func (p *Peer) Scopes() (scopes []string) {
+	if p.Uid == 0 {
+		scopes = []string{auth.ScopeAdmin}
+		return
+	}
+
+	scopes = []string{}
+	for _, scope := range strings.Split(
+		config.Local.Get("serverSocketScopes"), ",") {
+
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return
+}
+
+type peerConn struct {
+	net.Conn
+	peer *Peer
+}
+
+// Unix socket listener that drops callers missing from allowlist
+type socketListener struct {
+	net.Listener
+	uids map[int]bool
+	gids map[int]bool
+}
+
+func (l *socketListener) allowed(peer *Peer) bool {
+	return peer.Uid == 0 || l.uids[peer.Uid] || l.gids[peer.Gid]
+}
+
+func (l *socketListener) Accept() (conn net.Conn, err error) {
+	for {
+		conn, err = l.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		peer, e := getPeerCred(conn)
+		if e != nil {
+			log.Error("api: Failed to get socket peer", e)
+			conn.Close()
+			continue
+		}
+
+		if !l.allowed(peer) {
+			log.Warning("api: Socket peer not allowed", peer.Uid, peer.Gid)
+			conn.Close()
+			continue
+		}
+
+		conn = &peerConn{
+			Conn: conn,
+			peer: peer,
+		}
+		return
+	}
+}
+
+func parseIds(val string) (ids map[int]bool) {
+	ids = map[int]bool{}
+
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(item)
+		if err != nil {
+			log.Warning("api: Invalid socket allowlist id", item)
+			continue
+		}
+		ids[id] = true
+	}
+
+	return
+}
+
+// TCP listener, disabled when serverHostApi is empty. Host defaults to
+// loopback when only port is set.
+func listenTcp() (ln net.Listener, err error) {
+	addr := config.Local.Get("serverHostApi")
+	if addr == "" {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		err = errors.New("api: Invalid serverHostApi " + err.Error())
+		return
+	}
+	if host == "" {
+		host = defaultHostApi
+	}
+
+	ln, err = net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		err = errors.New("api: Failed to listen tcp " + err.Error())
+		return
+	}
+
+	return
+}
+
+// Unix socket listener, disabled when serverSocketApi is empty
+func listenSocket() (ln net.Listener, err error) {
+	pth := config.Local.Get("serverSocketApi")
+	if pth == "" {
+		return
+	}
+
+	if !peerCredSupported {
+		err = errors.New("api: Socket peer credentials not supported")
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(pth), 0755)
+	if err != nil {
+		err = errors.New("api: Failed to create socket directory " +
+			err.Error())
+		return
+	}
+	os.Remove(pth)
+
+	unixLn, err := net.Listen("unix", pth)
+	if err != nil {
+		err = errors.New("api: Failed to listen socket " + err.Error())
+		return
+	}
+
+	// access is checked with peer credentials
+	err = os.Chmod(pth, 0666)
+	if err != nil {
+		unixLn.Close()
+		err = errors.New("api: Failed to chmod socket " + err.Error())
+		return
+	}
+
+	ln = &socketListener{
+		Listener: unixLn,
+		uids:     parseIds(config.Local.Get("serverSocketUids")),
+		gids:     parseIds(config.Local.Get("serverSocketGids")),
+	}
+
+	return
+}
+
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if pConn, ok := conn.(*peerConn); ok {
+		return context.WithValue(ctx, peerKey{}, pConn.peer)
+	}
+	return ctx
+}
+
+// Get socket peer of request, nil for tcp requests
+func getPeer(c *gin.Context) *Peer {
+	peer, _ := c.Request.Context().Value(peerKey{}).(*Peer)
+	return peer
+}
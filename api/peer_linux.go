@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+const (
+	peerCredSupported = true
+)
+
+func getPeerCred(conn net.Conn) (peer *Peer, err error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		err = errors.New("api: Connection is not unix socket")
+		return
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		err = errors.New("api: Failed to get raw socket " + err.Error())
+		return
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd),
+			syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		err = errors.New("api: Failed to get peer credentials " + err.Error())
+		return
+	}
+
+	peer = &Peer{
+		Uid: int(cred.Uid),
+		Gid: int(cred.Gid),
+	}
+
+	return
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+const (
+	peerCredSupported = false
+)
+
+func getPeerCred(conn net.Conn) (peer *Peer, err error) {
+	err = errors.New("api: Peer credentials not implemented")
+	return
+}
@@ -2,40 +2,208 @@ package api
 
 import (
 	"../profile"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"runtime"
 )
 
 type profileData struct {
-	Id              string `json:"id"`
-	Data            string `json:"data"`
-	Username        string `json:"username"`
-	Password        string `json:"password"`
-	ServerPublicKey string `json:"server_public_key"`
-	Reconnect       bool   `json:"reconnect"`
-	Timeout         bool   `json:"timeout"`
+	Id              string                   `json:"id"`
+	Data            string                   `json:"data"`
+	Username        string                   `json:"username"`
+	Password        string                   `json:"password"`
+	ServerPublicKey string                   `json:"server_public_key"`
+	KeyPassphrase   string                   `json:"key_passphrase"`
+	Reconnect       bool                     `json:"reconnect"`
+	ReconnectPolicy *profile.ReconnectPolicy `json:"reconnect_policy"`
+	Timeouts        *profile.ConnectTimeouts `json:"timeouts"`
+	KillSwitch      bool                     `json:"kill_switch"`
+	IncludeRoutes   []string                 `json:"include_routes"`
+	ExcludeRoutes   []string                 `json:"exclude_routes"`
+	RouteConflict   string                   `json:"route_conflict"`
+	Timeout         bool                     `json:"timeout"`
+}
+
+type storedData struct {
+	Id              string                   `json:"id"`
+	Name            string                   `json:"name"`
+	Data            string                   `json:"data"`
+	Reconnect       bool                     `json:"reconnect"`
+	ReconnectPolicy *profile.ReconnectPolicy `json:"reconnect_policy"`
+	Timeouts        *profile.ConnectTimeouts `json:"timeouts"`
+	KillSwitch      bool                     `json:"kill_switch"`
+	IncludeRoutes   []string                 `json:"include_routes"`
+	ExcludeRoutes   []string                 `json:"exclude_routes"`
+	RouteConflict   string                   `json:"route_conflict"`
+	Username        string                   `json:"username,omitempty"`
+	Password        string                   `json:"password,omitempty"`
+	ServerPublicKey string                   `json:"server_public_key,omitempty"`
+	KeyPassphrase   string                   `json:"key_passphrase,omitempty"`
+	HasCredentials  bool                     `json:"has_credentials"`
+	KeyEncrypted    bool                     `json:"key_encrypted"`
+}
+
+type errorData struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Line      int    `json:"line,omitempty"`
+	Directive string `json:"directive,omitempty"`
+}
+
+// Reject profile data denied by directive policy
+func checkPolicy(c *gin.Context, data string) bool {
+	err := profile.CheckPolicy(data)
+	if err == nil {
+		return true
+	}
+
+	errData := &errorData{
+		Error:   "invalid_profile",
+		Message: err.Error(),
+	}
+
+	switch e := err.(type) {
+	case *profile.PolicyError:
+		errData.Error = "directive_denied"
+		errData.Line = e.Line
+		errData.Directive = e.Directive
+	case *profile.ParseError:
+		errData.Line = e.Line
+	}
+
+	c.Error(err)
+	c.JSON(400, errData)
+	return false
+}
+
+type profileInfo struct {
+	*profile.Profile
+	Stored         bool `json:"stored"`
+	HasCredentials bool `json:"has_credentials"`
 }
 
 func profileGet(c *gin.Context) {
-	c.JSON(200, profile.GetProfiles())
+	storeds, err := profile.GetStoredProfiles()
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	infos := map[string]*profileInfo{}
+
+	for _, stored := range storeds {
+		infos[stored.Id] = &profileInfo{
+			Profile: &profile.Profile{
+				Id:              stored.Id,
+				Name:            stored.Name,
+				Reconnect:       stored.Reconnect,
+				ReconnectPolicy: stored.ReconnectPolicy,
+				Timeouts:        stored.Timeouts,
+				KillSwitch:      stored.KillSwitch,
+				IncludeRoutes:   stored.IncludeRoutes,
+				ExcludeRoutes:   stored.ExcludeRoutes,
+				RouteConflict:   stored.RouteConflict,
+				Status:          profile.Disconnected,
+			},
+			Stored:         true,
+			HasCredentials: stored.HasCredentials(),
+		}
+	}
+
+	for id, prfl := range profile.GetProfiles() {
+		info := infos[id]
+		if info == nil {
+			info = &profileInfo{}
+			infos[id] = info
+		}
+		info.Profile = prfl
+	}
+
+	c.JSON(200, infos)
 }
 
 func profilePost(c *gin.Context) {
 	data := &profileData{}
 	c.Bind(data)
 
-	prfl := &profile.Profile{
-		Id:              data.Id,
-		Data:            data.Data,
-		Username:        data.Username,
-		Password:        data.Password,
-		ServerPublicKey: data.ServerPublicKey,
-		Reconnect:       data.Reconnect,
+	var prfl *profile.Profile
+
+	if data.Data == "" {
+		stored, err := profile.GetStored(data.Id)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		if stored == nil {
+			c.AbortWithError(404,
+				errors.New("api: Profile not found "+data.Id))
+			return
+		}
+
+		prfl, err = stored.NewProfile()
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+	} else {
+		if data.ReconnectPolicy != nil {
+			err := data.ReconnectPolicy.Validate()
+			if err != nil {
+				c.AbortWithError(400, err)
+				return
+			}
+		}
+
+		if !checkPolicy(c, data.Data) {
+			return
+		}
+
+		err := profile.ValidateSplitRoutes(data.IncludeRoutes,
+			data.ExcludeRoutes)
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		err = profile.ValidateRouteConflict(data.RouteConflict)
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		prfl = &profile.Profile{
+			Id:              data.Id,
+			Data:            data.Data,
+			Username:        data.Username,
+			Password:        data.Password,
+			ServerPublicKey: data.ServerPublicKey,
+			KeyPassphrase:   data.KeyPassphrase,
+			Reconnect:       data.Reconnect,
+			ReconnectPolicy: data.ReconnectPolicy,
+			Timeouts:        data.Timeouts,
+			KillSwitch:      data.KillSwitch,
+			IncludeRoutes:   data.IncludeRoutes,
+			ExcludeRoutes:   data.ExcludeRoutes,
+			RouteConflict:   data.RouteConflict,
+		}
+		prfl.Init()
+	}
+
+	// connecting always-on profile resumes suspended always-on mode
+	if prfl.Id == profile.GetAlwaysOn() {
+		profile.ResumeAlwaysOn()
+		prfl.Reconnect = true
+		prfl.KillSwitch = runtime.GOOS == "linux"
 	}
-	prfl.Init()
 
 	err := prfl.Start(data.Timeout)
 	if err != nil {
-		c.AbortWithError(500, err)
+		c.Error(err)
+		c.JSON(500, &errorData{
+			Error:   "start_failed",
+			Message: err.Error(),
+		})
 		return
 	}
 
@@ -46,6 +214,12 @@ func profileDel(c *gin.Context) {
 	data := &profileData{}
 	c.Bind(data)
 
+	if !alwaysOnGuard(c, profile.FilterStr(data.Id)) {
+		return
+	}
+
+	profile.CancelReconnect(profile.FilterStr(data.Id))
+
 	prfl := profile.GetProfile(data.Id)
 	if prfl != nil {
 		err := prfl.Stop()
@@ -55,5 +229,314 @@ func profileDel(c *gin.Context) {
 		}
 	}
 
+	profile.DisableKillSwitch(profile.FilterStr(data.Id))
+
+	c.JSON(200, nil)
+}
+
+func storedGet(c *gin.Context) {
+	stored, err := profile.GetStored(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	c.JSON(200, &storedData{
+		Id:              stored.Id,
+		Name:            stored.Name,
+		Data:            stored.Data,
+		Reconnect:       stored.Reconnect,
+		ReconnectPolicy: stored.ReconnectPolicy,
+		Timeouts:        stored.Timeouts,
+		KillSwitch:      stored.KillSwitch,
+		IncludeRoutes:   stored.IncludeRoutes,
+		ExcludeRoutes:   stored.ExcludeRoutes,
+		RouteConflict:   stored.RouteConflict,
+		HasCredentials:  stored.HasCredentials(),
+		KeyEncrypted:    profile.KeyEncrypted(stored.Data),
+	})
+}
+
+func storedPut(c *gin.Context) {
+	data := &storedData{}
+	c.Bind(data)
+
+	id := profile.FilterStr(c.Param("id"))
+	if id == "" || data.Data == "" {
+		c.AbortWithError(400,
+			errors.New("api: Profile id and data required"))
+		return
+	}
+
+	if !checkPolicy(c, data.Data) {
+		return
+	}
+
+	stored, err := profile.GetStored(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored == nil {
+		stored = &profile.StoredProfile{
+			Id: id,
+		}
+	}
+
+	if data.ReconnectPolicy != nil {
+		err = data.ReconnectPolicy.Validate()
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+	}
+
+	err = profile.ValidateSplitRoutes(data.IncludeRoutes, data.ExcludeRoutes)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	err = profile.ValidateRouteConflict(data.RouteConflict)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	stored.Name = data.Name
+	stored.Data = data.Data
+	stored.Reconnect = data.Reconnect
+	stored.ReconnectPolicy = data.ReconnectPolicy
+	stored.Timeouts = data.Timeouts
+	stored.KillSwitch = data.KillSwitch
+	stored.IncludeRoutes = data.IncludeRoutes
+	stored.ExcludeRoutes = data.ExcludeRoutes
+	stored.RouteConflict = data.RouteConflict
+
+	// credentials are kept when omitted
+	if data.Username != "" || data.Password != "" ||
+		data.ServerPublicKey != "" || data.KeyPassphrase != "" {
+
+		err = stored.UpdateCredentials(&profile.Credentials{
+			Username:        data.Username,
+			Password:        data.Password,
+			ServerPublicKey: data.ServerPublicKey,
+			KeyPassphrase:   data.KeyPassphrase,
+		})
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+	}
+
+	err = profile.SaveStored(stored)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
 	c.JSON(200, nil)
 }
+
+func storedDel(c *gin.Context) {
+	id := profile.FilterStr(c.Param("id"))
+
+	if !alwaysOnGuard(c, id) {
+		return
+	}
+
+	profile.CancelReconnect(id)
+
+	prfl := profile.GetProfile(id)
+	if prfl != nil {
+		err := prfl.Stop()
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+	}
+
+	profile.DisableKillSwitch(id)
+
+	stored, err := profile.GetStored(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored != nil {
+		err = stored.ForgetCredentials()
+		if err != nil {
+			log.Error("api: Failed to forget credentials", err)
+		}
+	}
+
+	err = profile.RemoveStored(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
+
+func credentialsDel(c *gin.Context) {
+	stored, err := profile.GetStored(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	err = stored.ForgetCredentials()
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	err = profile.SaveStored(stored)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
+
+func remotesGet(c *gin.Context) {
+	id := profile.FilterStr(c.Param("id"))
+
+	prfl := profile.GetProfile(id)
+	if prfl != nil {
+		c.JSON(200, prfl.GetRemotes())
+		return
+	}
+
+	stored, err := profile.GetStored(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if stored == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	c.JSON(200, profile.ProbeRemotes(stored.Data))
+}
+
+type challengeData struct {
+	Response string `json:"response"`
+}
+
+func challengePost(c *gin.Context) {
+	data := &challengeData{}
+	c.Bind(data)
+
+	prfl := profile.GetProfile(profile.FilterStr(c.Param("id")))
+	if prfl == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	err := prfl.RespondChallenge(data.Response)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
+
+type credentialsData struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	KeyPassphrase string `json:"key_passphrase"`
+	Remember      bool   `json:"remember"`
+}
+
+func credentialsPost(c *gin.Context) {
+	data := &credentialsData{}
+	c.Bind(data)
+
+	prfl := profile.GetProfile(profile.FilterStr(c.Param("id")))
+	if prfl == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	err := prfl.ProvideCredentials(&profile.Credentials{
+		Username:      data.Username,
+		Password:      data.Password,
+		KeyPassphrase: data.KeyPassphrase,
+	}, data.Remember)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	c.JSON(200, nil)
+}
+
+type validateData struct {
+	Data string `json:"data"`
+}
+
+func validatePost(c *gin.Context) {
+	data := &validateData{}
+	c.Bind(data)
+
+	c.JSON(200, profile.Validate(data.Data))
+}
+
+func routesGet(c *gin.Context) {
+	id := profile.FilterStr(c.Param("id"))
+
+	prfl := profile.GetProfile(id)
+	if prfl == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	c.JSON(200, prfl.GetNetTable())
+}
+
+func certificatesGet(c *gin.Context) {
+	id := profile.FilterStr(c.Param("id"))
+
+	data := ""
+	if prfl := profile.GetProfile(id); prfl != nil {
+		data = prfl.Data
+	} else {
+		stored, err := profile.GetStored(id)
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+
+		if stored == nil {
+			c.AbortWithStatus(404)
+			return
+		}
+		data = stored.Data
+	}
+
+	certs, err := profile.GetCertificates(data)
+	if err != nil {
+		c.AbortWithError(400, err)
+		return
+	}
+
+	c.JSON(200, certs)
+}
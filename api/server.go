@@ -4,8 +4,8 @@ import (
 	"../profile"
 	"../watch"
 	"context"
-	"github.com/AlexeySpiridonov/goapp-config"
 	"github.com/gin-gonic/gin"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,13 +13,7 @@ import (
 	"time"
 )
 
-var (
-	Key = ""
-)
-
-func runServer(authKey string) {
-	Key = authKey
-
+func runServer() {
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
@@ -28,14 +22,37 @@ func runServer(authKey string) {
 	watch.StartWatch()
 
 	server := &http.Server{
-		Addr:           config.Local.Get("serverHostApi"),
 		Handler:        router,
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		MaxHeaderBytes: 4096,
+		ConnContext:    connContext,
 	}
 
-	go run(server)
+	listeners := []net.Listener{}
+
+	tcpLn, err := listenTcp()
+	if err != nil {
+		log.Error("main: Server error", err)
+	} else if tcpLn != nil {
+		listeners = append(listeners, tcpLn)
+	}
+
+	socketLn, err := listenSocket()
+	if err != nil {
+		log.Error("main: Server error", err)
+	} else if socketLn != nil {
+		listeners = append(listeners, socketLn)
+	}
+
+	if len(listeners) == 0 {
+		log.Error("main: No api listeners configured")
+	}
+
+	for _, ln := range listeners {
+		go run(server, ln)
+	}
+	go hupWatch()
 
 	sig := make(chan os.Signal, 2)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
@@ -58,25 +75,49 @@ func runServer(authKey string) {
 	getProfiles()
 }
 
-func run(server *http.Server) {
+func run(server *http.Server, ln net.Listener) {
 	defer func() {
 		recover()
 	}()
 
-	err := server.ListenAndServe()
-	if err != nil {
+	err := server.Serve(ln)
+	if err != nil && err != http.ErrServerClosed {
 		log.Error("main: Server error", err)
 		return
 	}
 }
 
+// Rotate auth key on SIGHUP
+func hupWatch() {
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Panic("api: Panic", err)
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		err := rotateKey()
+		if err != nil {
+			log.Error("api: Failed to rotate auth key", err)
+		}
+	}
+}
+
 func getProfiles() {
 	time.Sleep(250 * time.Millisecond)
 
+	profile.CancelReconnects()
+
 	prfls := profile.GetProfiles()
 	for _, prfl := range prfls {
 		prfl.Stop()
 	}
 
 	time.Sleep(750 * time.Millisecond)
+
+	profile.DisableKillSwitches()
 }
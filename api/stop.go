@@ -1,16 +1,48 @@
 package api
 
 import (
+	"../auth"
 	"../autoclean"
 	"../profile"
+	"errors"
 	"github.com/gin-gonic/gin"
 )
 
+// Refuse to stop always-on profile, admin can override with override
+// query which suspends always-on mode. Empty id guards all profiles.
+func alwaysOnGuard(c *gin.Context, id string) bool {
+	if !profile.IsAlwaysOn(id) {
+		return true
+	}
+
+	if c.Query("override") == "true" &&
+		auth.HasScope(c.GetStringSlice("scopes"), auth.ScopeAdmin) {
+
+		profile.SuspendAlwaysOn()
+		return true
+	}
+
+	err := errors.New("api: Profile is always-on " + profile.GetAlwaysOn())
+	c.Error(err)
+	c.JSON(403, &errorData{
+		Error:   "always_on",
+		Message: err.Error(),
+	})
+	return false
+}
+
 func stopPost(c *gin.Context) {
+	if !alwaysOnGuard(c, "") {
+		return
+	}
+
+	profile.CancelReconnects()
+
 	prfls := profile.GetProfiles()
 	for _, prfl := range prfls {
 		prfl.Stop()
 	}
+	profile.DisableKillSwitches()
 
 	autoclean.CheckAndCleanWatch()
 
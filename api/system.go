@@ -0,0 +1,10 @@
+package api
+
+import (
+	"../profile"
+	"github.com/gin-gonic/gin"
+)
+
+func openvpnGet(c *gin.Context) {
+	c.JSON(200, profile.GetOpenvpn())
+}
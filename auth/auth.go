@@ -2,29 +2,48 @@ package auth
 
 import (
 	"../shared/utils"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
 	"github.com/op/go-logging"
 	"io/ioutil"
 	"os"
+	"os/user"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
 	log = logging.MustGetLogger("auth")
 )
 
-var Key = ""
+var (
+	Key     = ""
+	keyLock sync.RWMutex
+)
+
+// Get current auth key
+func Get() string {
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+	return Key
+}
 
 func Init() {
+	err := loadTokens()
+	if err != nil {
+		log.Error("auth: Failed to load tokens", err)
+	}
+
+	initKey()
+}
+
+func initKey() {
 	pth := utils.GetAuthPath()
 
 	if _, err := os.Stat(pth); os.IsNotExist(err) {
-		Key, err = utils.RandStr(64)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-
-		err = ioutil.WriteFile(pth, []byte(Key), os.FileMode(0644))
+		_, err = Rotate()
 		if err != nil {
 			log.Error("auth: Failed to auth key", err)
 			return
@@ -36,15 +55,97 @@ func Init() {
 			return
 		}
 
-		Key = strings.TrimSpace(string(data))
+		key := strings.TrimSpace(string(data))
 
-		if Key == "" {
+		if key == "" {
 			err = os.Remove(pth)
 			if err != nil {
 				log.Error("auth: Failed to reset auth key", err)
 				return
 			}
-			Init()
+			initKey()
+			return
+		}
+
+		// older versions wrote the key world readable
+		err = protect(pth)
+		if err != nil {
+			log.Error("auth: Failed to protect auth key", err)
 		}
+
+		keyLock.Lock()
+		Key = key
+		keyLock.Unlock()
 	}
 }
+
+// Generate new auth key and replace key file
+func Rotate() (key string, err error) {
+	key, err = utils.RandStr(64)
+	if err != nil {
+		return
+	}
+
+	pth := utils.GetAuthPath()
+	tmpPth := pth + ".tmp"
+
+	err = ioutil.WriteFile(tmpPth, []byte(key), os.FileMode(0600))
+	if err != nil {
+		err = errors.New("auth: Failed to write auth key " + err.Error())
+		return
+	}
+
+	err = protect(tmpPth)
+	if err != nil {
+		os.Remove(tmpPth)
+		return
+	}
+
+	err = os.Rename(tmpPth, pth)
+	if err != nil {
+		os.Remove(tmpPth)
+		err = errors.New("auth: Failed to replace auth key " + err.Error())
+		return
+	}
+
+	keyLock.Lock()
+	Key = key
+	keyLock.Unlock()
+
+	return
+}
+
+// Key file readable only by root and group from authGroup config
+func protect(pth string) (err error) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	err = os.Chmod(pth, os.FileMode(0640))
+	if err != nil {
+		err = errors.New("auth: Failed to chmod auth key " + err.Error())
+		return
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	gid := 0
+	if name := config.Local.Get("authGroup"); name != "" {
+		grp, e := user.LookupGroup(name)
+		if e != nil {
+			log.Warning("auth: Auth group not found", name)
+		} else {
+			gid, _ = strconv.Atoi(grp.Gid)
+		}
+	}
+
+	err = os.Chown(pth, 0, gid)
+	if err != nil {
+		err = errors.New("auth: Failed to chown auth key " + err.Error())
+		return
+	}
+
+	return
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"../shared/utils"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	ScopeRead    = "read"
+	ScopeControl = "control"
+	ScopeAdmin   = "admin"
+)
+
+var (
+	Tokens = struct {
+		sync.RWMutex
+		m map[string]*Token
+	}{
+		m: map[string]*Token{},
+	}
+	validScopes = map[string]bool{
+		ScopeRead:    true,
+		ScopeControl: true,
+		ScopeAdmin:   true,
+	}
+)
+
+// Named api token, only hash of key is stored
+type Token struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return HasScope(t.Scopes, scope)
+}
+
+// Admin scope grants every other scope
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func getTokensPath() (pth string, err error) {
+	dataDir, err := utils.GetDataDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(dataDir, "tokens.json")
+	return
+}
+
+func loadTokens() (err error) {
+	pth, err := getTokensPath()
+	if err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+		err = errors.New("auth: Failed to read tokens " + err.Error())
+		return
+	}
+
+	tokens := []*Token{}
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		err = errors.New("auth: Failed to parse tokens " + err.Error())
+		return
+	}
+
+	Tokens.Lock()
+	Tokens.m = map[string]*Token{}
+	for _, tokn := range tokens {
+		Tokens.m[tokn.Name] = tokn
+	}
+	Tokens.Unlock()
+
+	return
+}
+
+// Must be called with Tokens lock held
+func saveTokens() (err error) {
+	pth, err := getTokensPath()
+	if err != nil {
+		return
+	}
+
+	tokens := []*Token{}
+	for _, tokn := range Tokens.m {
+		tokens = append(tokens, tokn)
+	}
+
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		err = errors.New("auth: Failed to encode tokens " + err.Error())
+		return
+	}
+
+	tmpPth := pth + ".tmp"
+
+	err = ioutil.WriteFile(tmpPth, data, os.FileMode(0600))
+	if err != nil {
+		err = errors.New("auth: Failed to write tokens " + err.Error())
+		return
+	}
+
+	err = os.Rename(tmpPth, pth)
+	if err != nil {
+		os.Remove(tmpPth)
+		err = errors.New("auth: Failed to replace tokens " + err.Error())
+		return
+	}
+
+	return
+}
+
+// Get scopes granted to key, auth key grants admin
+func GetScopes(key string) (scopes []string, ok bool) {
+	if key == "" {
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(Get())) == 1 {
+		scopes = []string{ScopeAdmin}
+		ok = true
+		return
+	}
+
+	hash := hashKey(key)
+
+	Tokens.RLock()
+	defer Tokens.RUnlock()
+
+	for _, tokn := range Tokens.m {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(tokn.Hash)) == 1 {
+			scopes = tokn.Scopes
+			ok = true
+			return
+		}
+	}
+
+	return
+}
+
+func GetTokens() (tokens []*Token) {
+	tokens = []*Token{}
+
+	Tokens.RLock()
+	for _, tokn := range Tokens.m {
+		tokens = append(tokens, tokn)
+	}
+	Tokens.RUnlock()
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+
+	return
+}
+
+// Create token and return key, key is not stored and can't be recovered
+func NewToken(name string, scopes []string) (tokn *Token, key string,
+	err error) {
+
+	if name == "" {
+		err = errors.New("auth: Token name required")
+		return
+	}
+
+	if len(scopes) == 0 {
+		err = errors.New("auth: Token scopes required")
+		return
+	}
+
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			err = errors.New("auth: Invalid token scope " + scope)
+			return
+		}
+	}
+
+	key, err = utils.RandStr(64)
+	if err != nil {
+		return
+	}
+
+	tokn = &Token{
+		Name:   name,
+		Hash:   hashKey(key),
+		Scopes: scopes,
+	}
+
+	Tokens.Lock()
+	defer Tokens.Unlock()
+
+	if _, ok := Tokens.m[name]; ok {
+		err = errors.New("auth: Token already exists " + name)
+		return
+	}
+
+	Tokens.m[name] = tokn
+
+	err = saveTokens()
+	if err != nil {
+		delete(Tokens.m, name)
+		return
+	}
+
+	return
+}
+
+func RemoveToken(name string) (err error) {
+	Tokens.Lock()
+	defer Tokens.Unlock()
+
+	tokn, ok := Tokens.m[name]
+	if !ok {
+		return
+	}
+
+	delete(Tokens.m, name)
+
+	err = saveTokens()
+	if err != nil {
+		Tokens.m[name] = tokn
+		return
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"./profile"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Run command line command instead of service, returns false when
+// arguments do not name a command
+func runCmd(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "import":
+		err := importCmd(args[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Import profile with referenced files relative to profile path, pkcs12
+// password is read from PKCS12_PASSWORD
+func importCmd(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	id := flags.String("id", "", "profile id, defaults to file name")
+	name := flags.String("name", "", "profile name")
+	bundle := flags.String("pkcs12", "", "pkcs12 bundle to append")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		err = fmt.Errorf("usage: import [-id id] [-name name] " +
+			"[-pkcs12 bundle.p12] profile.ovpn")
+		return
+	}
+	pth := flags.Arg(0)
+
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return
+	}
+
+	dir := filepath.Dir(pth)
+	resolve := func(ref string) ([]byte, error) {
+		if !filepath.IsAbs(ref) {
+			ref = filepath.Join(dir, ref)
+		}
+		return ioutil.ReadFile(ref)
+	}
+	password := os.Getenv("PKCS12_PASSWORD")
+
+	prflData, err := profile.Import(string(data), resolve, password)
+	if err != nil {
+		return
+	}
+
+	if *bundle != "" {
+		bundleData, e := ioutil.ReadFile(*bundle)
+		if e != nil {
+			err = e
+			return
+		}
+
+		prflData, err = profile.ImportPkcs12(prflData, bundleData, password)
+		if err != nil {
+			return
+		}
+	}
+
+	err = profile.CheckPolicy(prflData)
+	if err != nil {
+		return
+	}
+
+	if *id == "" {
+		*id = strings.TrimSuffix(filepath.Base(pth), filepath.Ext(pth))
+	}
+
+	stored, err := profile.GetStored(*id)
+	if err != nil {
+		return
+	}
+
+	if stored == nil {
+		stored = &profile.StoredProfile{
+			Id: *id,
+		}
+	}
+
+	stored.Name = *name
+	if stored.Name == "" {
+		stored.Name = *id
+	}
+	stored.Data = prflData
+
+	err = profile.SaveStored(stored)
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Imported profile", stored.Id)
+
+	return
+}
@@ -4,8 +4,10 @@ import (
 	"./api"
 	"./auth"
 	"./autoclean"
+	"./profile"
 	"./shared/utils"
 	"github.com/op/go-logging"
+	"os"
 )
 
 var (
@@ -16,6 +18,11 @@ func main() {
 	// при старте первым аргументом передаем тип окружения "dev", "prod" etc
 	// config.Local.Name = "dev"
 
+	// команды командной строки выполняются без запуска сервиса
+	if runCmd(os.Args[1:]) {
+		return
+	}
+
 	// инитим процесс
 	err := utils.PidInit()
 	if err != nil {
@@ -28,5 +35,14 @@ func main() {
 
 	auth.Init()
 	autoclean.Init()
-	api.Init(auth.Key)
+	profile.ProbeOpenvpn()
+	// правила kill switch могли остаться после падения, таблицу always-on
+	// заменяет блокировка в StartAlwaysOn без окна с открытым трафиком
+	if profile.GetAlwaysOn() == "" {
+		profile.DisableKillSwitches()
+	}
+	// маршруты профилей могли остаться после падения
+	profile.CleanNetTables()
+	profile.StartAlwaysOn()
+	api.Init()
 }
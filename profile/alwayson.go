@@ -0,0 +1,154 @@
+package profile
+
+import (
+	"github.com/AlexeySpiridonov/goapp-config"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	alwaysOnInterval = 5 * time.Second
+)
+
+var (
+	alwaysOn = struct {
+		sync.Mutex
+		suspended bool
+		attempts  int
+		next      time.Time
+	}{}
+)
+
+// Get stored profile id of always-on mode from alwaysOn config
+func GetAlwaysOn() string {
+	return FilterStr(config.Local.Get("alwaysOn"))
+}
+
+// Check if profile is kept connected by always-on mode, empty id checks
+// if mode is active
+func IsAlwaysOn(id string) bool {
+	alwaysOnId := GetAlwaysOn()
+	if alwaysOnId == "" || (id != "" && id != alwaysOnId) {
+		return false
+	}
+
+	alwaysOn.Lock()
+	defer alwaysOn.Unlock()
+	return !alwaysOn.suspended
+}
+
+// Suspend always-on mode until profile is connected again
+func SuspendAlwaysOn() {
+	alwaysOn.Lock()
+	alwaysOn.suspended = true
+	alwaysOn.Unlock()
+
+	log.Warning("profile: Always-on suspended", GetAlwaysOn())
+}
+
+func ResumeAlwaysOn() {
+	alwaysOn.Lock()
+	suspended := alwaysOn.suspended
+	alwaysOn.suspended = false
+	alwaysOn.attempts = 0
+	alwaysOn.Unlock()
+
+	if suspended {
+		log.Info("profile: Always-on resumed", GetAlwaysOn())
+		LockdownKillSwitches()
+	}
+}
+
+// Connect always-on profile and keep it connected, traffic is blocked
+// with kill switch while tunnel is down
+func StartAlwaysOn() {
+	id := GetAlwaysOn()
+	if id == "" {
+		return
+	}
+
+	if runtime.GOOS != "linux" {
+		log.Warning("profile: Always-on lockdown not supported on " +
+			runtime.GOOS)
+	}
+
+	LockdownKillSwitches()
+
+	go alwaysOnWatch(id)
+}
+
+func alwaysOnWatch(id string) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Panic("profile: Panic", err)
+		}
+	}()
+
+	for {
+		alwaysOnCheck(id)
+		time.Sleep(alwaysOnInterval)
+	}
+}
+
+func alwaysOnCheck(id string) {
+	prfl := alwaysOnProfile(id)
+	if prfl == nil {
+		return
+	}
+
+	err := prfl.Start(false)
+	if err != nil {
+		log.Error("profile: Always-on start error", err)
+	}
+}
+
+// Get profile to start when always-on profile is down, nil while
+// connected, reconnecting or backing off
+func alwaysOnProfile(id string) (prfl *Profile) {
+	alwaysOn.Lock()
+	defer alwaysOn.Unlock()
+
+	if alwaysOn.suspended {
+		return
+	}
+
+	if cur := GetProfile(id); cur != nil {
+		if cur.Status == Connected {
+			alwaysOn.attempts = 0
+		}
+		return
+	}
+
+	reconnects.Lock()
+	_, pending := reconnects.m[id]
+	reconnects.Unlock()
+
+	if pending || time.Now().Before(alwaysOn.next) {
+		return
+	}
+
+	stored, err := GetStored(id)
+	if err == nil && stored != nil {
+		prfl, err = stored.NewProfile()
+	}
+	if err != nil || prfl == nil {
+		log.Error("profile: Always-on profile not available", id, err)
+		alwaysOn.next = time.Now().Add(defaultMaxBackoff * time.Second)
+		prfl = nil
+		return
+	}
+
+	prfl.Reconnect = true
+	prfl.ReconnectPolicy = DefaultReconnectPolicy()
+	prfl.KillSwitch = runtime.GOOS == "linux"
+
+	alwaysOn.attempts += 1
+	alwaysOn.next = reserveStart(
+		prfl.ReconnectPolicy.Backoff(alwaysOn.attempts))
+
+	log.Info("profile: Always-on connecting", id, alwaysOn.attempts)
+
+	return
+}
@@ -0,0 +1,190 @@
+package profile
+
+import (
+	"../shared/events"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExpiryDays = 30
+	expiryWarnRate    = 24 * time.Hour
+)
+
+var (
+	expiryWarned = struct {
+		sync.Mutex
+		m map[string]time.Time
+	}{
+		m: map[string]time.Time{},
+	}
+)
+
+// Certificate embedded in profile, block is ca, cert or extra-certs
+type Certificate struct {
+	Block       string `json:"block"`
+	Subject     string `json:"subject"`
+	Issuer      string `json:"issuer"`
+	Serial      string `json:"serial"`
+	NotBefore   int64  `json:"not_before"`
+	NotAfter    int64  `json:"not_after"`
+	Fingerprint string `json:"fingerprint"`
+	Expired     bool   `json:"expired"`
+	DaysLeft    int    `json:"days_left"`
+}
+
+type CertificateData struct {
+	Id          string       `json:"id"`
+	Name        string       `json:"name"`
+	Certificate *Certificate `json:"certificate"`
+}
+
+// Days before expiry to warn from certificateExpiryDays config
+func getExpiryDays() int {
+	days, err := strconv.Atoi(config.Local.Get("certificateExpiryDays"))
+	if err != nil || days < 0 {
+		return defaultExpiryDays
+	}
+	return days
+}
+
+func fingerprint(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(parts, ":")
+}
+
+// Parse certificates from inline blocks of profile data
+func GetCertificates(data string) (certs []*Certificate, err error) {
+	certs = []*Certificate{}
+
+	conf, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, block := range conf.Blocks {
+		switch block.Name {
+		case "ca", "cert", "extra-certs":
+		default:
+			continue
+		}
+
+		rest := []byte(block.Data)
+		for {
+			var pemBlock *pem.Block
+			pemBlock, rest = pem.Decode(rest)
+			if pemBlock == nil {
+				break
+			}
+			if pemBlock.Type != "CERTIFICATE" {
+				continue
+			}
+
+			cert, e := x509.ParseCertificate(pemBlock.Bytes)
+			if e != nil {
+				err = errors.New("profile: Failed to parse certificate in <" +
+					block.Name + "> " + e.Error())
+				return
+			}
+
+			certs = append(certs, &Certificate{
+				Block:       block.Name,
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Serial:      cert.SerialNumber.String(),
+				NotBefore:   cert.NotBefore.Unix(),
+				NotAfter:    cert.NotAfter.Unix(),
+				Fingerprint: fingerprint(cert.Raw),
+				Expired:     now.After(cert.NotAfter),
+				DaysLeft:    int(cert.NotAfter.Sub(now).Hours() / 24),
+			})
+		}
+	}
+
+	return
+}
+
+// Emit certificate_expiring for certificates expiring within configured
+// days at most once a day, expired client certificate is returned as error
+func CheckCertificates(id, name, data string) (err error) {
+	certs, e := GetCertificates(data)
+	if e != nil {
+		log.Warning("profile: Failed to check certificates", id, e)
+		return
+	}
+
+	days := getExpiryDays()
+
+	for _, cert := range certs {
+		if cert.Block == "cert" && cert.Expired {
+			err = errors.New("profile: Client certificate expired " +
+				time.Unix(cert.NotAfter, 0).UTC().Format(time.RFC3339))
+			return
+		}
+
+		if cert.DaysLeft >= days {
+			continue
+		}
+
+		key := id + cert.Fingerprint
+		expiryWarned.Lock()
+		if time.Since(expiryWarned.m[key]) < expiryWarnRate {
+			expiryWarned.Unlock()
+			continue
+		}
+		expiryWarned.m[key] = time.Now()
+		expiryWarned.Unlock()
+
+		log.Warning("profile: Certificate expiring", id, cert.Subject,
+			cert.DaysLeft)
+
+		evt := events.Event{
+			Type: "certificate_expiring",
+			Data: &CertificateData{
+				Id:          id,
+				Name:        name,
+				Certificate: cert,
+			},
+		}
+		evt.Init()
+	}
+
+	return
+}
+
+// Check certificates of stored and active profiles
+func CheckAllCertificates() {
+	checked := map[string]bool{}
+
+	for id, prfl := range GetProfiles() {
+		checked[id] = true
+		CheckCertificates(prfl.Id, prfl.Name, prfl.Data)
+	}
+
+	storeds, err := GetStoredProfiles()
+	if err != nil {
+		log.Error("profile: Failed to check certificates", err)
+		return
+	}
+
+	for _, stored := range storeds {
+		if checked[stored.Id] {
+			continue
+		}
+		CheckCertificates(stored.Id, stored.Name, stored.Data)
+	}
+}
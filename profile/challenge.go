@@ -0,0 +1,189 @@
+package profile
+
+import (
+	"../shared/events"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChallengeStatic  = "static"
+	ChallengeDynamic = "dynamic"
+)
+
+// Pending auth challenge, response is sent once openvpn requests auth and
+// client has answered
+type challenge struct {
+	kind      string
+	prompt    string
+	echo      bool
+	concat    bool
+	stateId   string
+	username  string
+	needAuth  bool
+	response  string
+	responded bool
+}
+
+type ChallengeData struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Prompt string `json:"prompt"`
+	Echo   bool   `json:"echo"`
+}
+
+// Parse static challenge from "Need 'Auth' username/password SC:1,text"
+func parseStaticChallenge(msg string) (chal *challenge) {
+	index := strings.Index(msg, " SC:")
+	if index == -1 {
+		return
+	}
+
+	spl := strings.SplitN(msg[index+4:], ",", 2)
+	if len(spl) != 2 {
+		return
+	}
+
+	flags, _ := strconv.Atoi(spl[0])
+
+	chal = &challenge{
+		kind:     ChallengeStatic,
+		prompt:   spl[1],
+		echo:     flags&1 != 0,
+		concat:   flags&2 != 0,
+		needAuth: true,
+	}
+	return
+}
+
+// Parse dynamic challenge from
+// "Verification Failed: 'Auth' ['CRV1:flags:state_id:username_b64:text']"
+func parseDynamicChallenge(msg string) (chal *challenge) {
+	index := strings.Index(msg, "CRV1:")
+	if index == -1 {
+		return
+	}
+
+	crv := strings.TrimSuffix(msg[index+5:], "']")
+	spl := strings.SplitN(crv, ":", 4)
+	if len(spl) != 4 {
+		return
+	}
+
+	username, err := base64.StdEncoding.DecodeString(spl[2])
+	if err != nil {
+		return
+	}
+
+	chal = &challenge{
+		kind:     ChallengeDynamic,
+		prompt:   spl[3],
+		echo:     strings.Contains(spl[0], "E"),
+		stateId:  spl[1],
+		username: string(username),
+	}
+	return
+}
+
+func (p *Profile) startChallenge(chal *challenge) {
+	p.challengeLock.Lock()
+	p.challenge = chal
+	p.challengeLock.Unlock()
+
+	p.setState(AuthPending, "challenge")
+
+	evt := events.Event{
+		Type: "auth_challenge",
+		Data: &ChallengeData{
+			Id:     p.Id,
+			Type:   chal.kind,
+			Prompt: chal.prompt,
+			Echo:   chal.echo,
+		},
+	}
+	evt.Init()
+}
+
+func (p *Profile) hasChallenge() bool {
+	p.challengeLock.Lock()
+	defer p.challengeLock.Unlock()
+	return p.challenge != nil
+}
+
+// Mark openvpn waiting for auth, returns false without pending challenge
+func (p *Profile) challengeNeedAuth() bool {
+	p.challengeLock.Lock()
+	chal := p.challenge
+	if chal != nil {
+		chal.needAuth = true
+	}
+	p.challengeLock.Unlock()
+
+	if chal == nil {
+		return false
+	}
+
+	p.sendChallenge()
+	return true
+}
+
+// Answer pending challenge
+func (p *Profile) RespondChallenge(response string) (err error) {
+	p.challengeLock.Lock()
+	chal := p.challenge
+	if chal != nil {
+		chal.response = response
+		chal.responded = true
+	}
+	p.challengeLock.Unlock()
+
+	if chal == nil {
+		err = errors.New("profile: No pending challenge")
+		return
+	}
+
+	p.sendChallenge()
+	return
+}
+
+func (p *Profile) sendChallenge() {
+	p.challengeLock.Lock()
+	chal := p.challenge
+	if chal == nil || !chal.needAuth || !chal.responded {
+		p.challengeLock.Unlock()
+		return
+	}
+	p.challenge = nil
+	p.challengeLock.Unlock()
+
+	username := p.Username
+	password := ""
+
+	switch chal.kind {
+	case ChallengeStatic:
+		if chal.concat {
+			password = p.Password + chal.response
+		} else {
+			password = "SCRV1:" +
+				base64.StdEncoding.EncodeToString([]byte(p.Password)) + ":" +
+				base64.StdEncoding.EncodeToString([]byte(chal.response))
+		}
+	case ChallengeDynamic:
+		username = chal.username
+		password = "CRV1::" + chal.stateId + "::" + chal.response
+	}
+
+	p.setState(Connecting, "challenge_response")
+
+	mgmt := p.mgmt
+	if mgmt == nil {
+		return
+	}
+
+	err := mgmt.sendAuth(username, password)
+	if err != nil {
+		log.Error("profile: Management error", err)
+	}
+}
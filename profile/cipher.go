@@ -0,0 +1,77 @@
+package profile
+
+import (
+	"strings"
+)
+
+var (
+	// negotiable data ciphers of openvpn 2.5 and later
+	modernCiphers = []string{
+		"AES-256-GCM",
+		"AES-128-GCM",
+		"CHACHA20-POLY1305",
+	}
+)
+
+// Option added on launch, profile data is not modified
+type Change struct {
+	Directive string `json:"directive"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason"`
+}
+
+// Derive data-ciphers for legacy profile with cipher and no negotiable
+// cipher list, openvpn 2.5 and later no longer uses cipher alone
+func cipherChanges(conf *Config) (changes []*Change) {
+	changes = []*Change{}
+
+	if !GetOpenvpn().AtLeast(2, 5) || conf.Has("data-ciphers") ||
+		conf.Has("ncp-ciphers") {
+
+		return
+	}
+
+	drct := conf.Last("cipher")
+	if drct == nil || len(drct.Args) == 0 {
+		return
+	}
+	cipher := strings.ToUpper(drct.Args[0])
+
+	ciphers := []string{}
+	for _, c := range modernCiphers {
+		if c != cipher {
+			ciphers = append(ciphers, c)
+		}
+	}
+	ciphers = append(ciphers, cipher)
+
+	changes = append(changes, &Change{
+		Directive: "data-ciphers",
+		Value:     strings.Join(ciphers, ":"),
+		Reason:    "negotiate modern cipher, keep " + cipher + " for server",
+	}, &Change{
+		Directive: "data-ciphers-fallback",
+		Value:     cipher,
+		Reason:    "server without cipher negotiation uses " + cipher,
+	})
+
+	return
+}
+
+// Launch arguments for changes to profile
+func (p *Profile) changeArgs() (args []string) {
+	args = []string{}
+
+	conf, err := Parse(p.Data)
+	if err != nil {
+		return
+	}
+
+	for _, change := range cipherChanges(conf) {
+		log.Info("profile: Adding option", p.Id, change.Directive,
+			change.Value)
+		args = append(args, "--"+change.Directive, change.Value)
+	}
+
+	return
+}
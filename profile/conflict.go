@@ -0,0 +1,172 @@
+package profile
+
+import (
+	"../shared/events"
+	"errors"
+	"net"
+)
+
+const (
+	ConflictKeepLocal = "keep_local"
+	ConflictReject    = "reject"
+	ConflictReport    = "report"
+)
+
+// Pushed route overlapping subnet of local interface, action is
+// keep_local when more specific local route already wins, reject when
+// pushed route is not installed and report when routes are unchanged
+type RouteConflict struct {
+	Route     string `json:"route"`
+	Local     string `json:"local"`
+	Interface string `json:"interface"`
+	Action    string `json:"action"`
+}
+
+type RouteConflictData struct {
+	Id        string           `json:"id"`
+	Name      string           `json:"name"`
+	Policy    string           `json:"policy"`
+	Conflicts []*RouteConflict `json:"conflicts"`
+}
+
+type localSubnet struct {
+	intf  string
+	ipNet *net.IPNet
+}
+
+// Validate route conflict policy, empty policy keeps local routes
+func ValidateRouteConflict(policy string) (err error) {
+	switch policy {
+	case "", ConflictKeepLocal, ConflictReject, ConflictReport:
+	default:
+		err = errors.New("profile: Invalid route conflict policy " + policy)
+	}
+	return
+}
+
+// Subnets of local interfaces excluding loopback, point to point and
+// tunnel interface of profile
+func localSubnets(dev string) (subnets []*localSubnet) {
+	intfs, err := net.Interfaces()
+	if err != nil {
+		log.Warning("profile: Failed to get interfaces", err)
+		return
+	}
+
+	for _, intf := range intfs {
+		if intf.Flags&net.FlagUp == 0 ||
+			intf.Flags&net.FlagLoopback != 0 ||
+			intf.Flags&net.FlagPointToPoint != 0 ||
+			intf.Name == dev {
+
+			continue
+		}
+
+		addrs, err := intf.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			subnets = append(subnets, &localSubnet{
+				intf: intf.Name,
+				ipNet: &net.IPNet{
+					IP:   ipNet.IP.Mask(ipNet.Mask),
+					Mask: ipNet.Mask,
+				},
+			})
+		}
+	}
+
+	return
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Compare pushed routes with local subnets, kernel prefers more specific
+// connected route over pushed route covering local subnet so only pushed
+// routes as or more specific than local subnet are rejected
+func (p *Profile) checkRouteConflicts(env *routeEnv) {
+	subnets := localSubnets(env.dev)
+	if len(subnets) == 0 {
+		return
+	}
+
+	policy := p.RouteConflict
+	if policy == "" {
+		policy = ConflictKeepLocal
+	}
+	// routes installed by openvpn can only be reported
+	if !netlinkSupported {
+		policy = ConflictReport
+	}
+
+	conflicts := []*RouteConflict{}
+	routes := []*Route{}
+
+	for _, route := range env.routes {
+		_, ipNet, err := net.ParseCIDR(route.Network)
+		if err != nil || route.Source != RoutePushed {
+			routes = append(routes, route)
+			continue
+		}
+
+		rejected := false
+
+		for _, subnet := range subnets {
+			if !overlaps(ipNet, subnet.ipNet) {
+				continue
+			}
+
+			routeOnes, _ := ipNet.Mask.Size()
+			localOnes, _ := subnet.ipNet.Mask.Size()
+
+			action := policy
+			if action == ConflictKeepLocal && localOnes <= routeOnes {
+				action = ConflictReject
+			}
+
+			conflict := &RouteConflict{
+				Route:     route.Network,
+				Local:     subnet.ipNet.String(),
+				Interface: subnet.intf,
+				Action:    action,
+			}
+			conflicts = append(conflicts, conflict)
+
+			log.Warning("profile: Route conflict", p.Id, conflict.Route,
+				conflict.Local, conflict.Action)
+
+			if action == ConflictReject {
+				rejected = true
+			}
+		}
+
+		if !rejected {
+			routes = append(routes, route)
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return
+	}
+	env.routes = routes
+
+	evt := events.Event{
+		Type: "route_conflict",
+		Data: &RouteConflictData{
+			Id:        p.Id,
+			Name:      p.Name,
+			Policy:    policy,
+			Conflicts: conflicts,
+		},
+	}
+	evt.Init()
+}
@@ -0,0 +1,267 @@
+package profile
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/pkcs12"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// directives referencing files that are inlined on import
+	fileDirectives = map[string]bool{
+		"ca":           true,
+		"cert":         true,
+		"key":          true,
+		"extra-certs":  true,
+		"tls-auth":     true,
+		"tls-crypt":    true,
+		"tls-crypt-v2": true,
+		"pkcs12":       true,
+	}
+)
+
+// Reads file referenced by profile
+type ImportResolver func(pth string) (data []byte, err error)
+
+// Missing file reference with line of directive
+type MissingRef struct {
+	Line      int    `json:"line"`
+	Directive string `json:"directive"`
+	Path      string `json:"path"`
+}
+
+type ImportError struct {
+	Missing []*MissingRef
+}
+
+func (e *ImportError) Error() string {
+	refs := []string{}
+	for _, ref := range e.Missing {
+		refs = append(refs, "line "+strconv.Itoa(ref.Line)+" "+
+			ref.Directive+" "+ref.Path)
+	}
+	return "profile: Missing referenced files " + strings.Join(refs, ", ")
+}
+
+// Resolve files from map of uploaded files, paths are matched by full
+// path then by base name
+func MapResolver(files map[string][]byte) ImportResolver {
+	return func(pth string) (data []byte, err error) {
+		data, ok := files[pth]
+		if !ok {
+			data, ok = files[filepath.Base(filepath.FromSlash(pth))]
+		}
+		if !ok {
+			err = errors.New("profile: File not found " + pth)
+			return
+		}
+		return
+	}
+}
+
+func inlineBlock(name string, data []byte) string {
+	return "<" + name + ">\n" + strings.TrimSpace(string(data)) +
+		"\n</" + name + ">"
+}
+
+// Key bytes from pkcs12 are PKCS#1 or SEC 1 despite PRIVATE KEY type,
+// re-encode as PKCS#8
+func pkcs8Key(der []byte) (data []byte, err error) {
+	var key interface{}
+
+	key, err = x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		key, err = x509.ParseECPrivateKey(der)
+	}
+	if err != nil {
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	}
+	if err != nil {
+		err = errors.New("profile: Failed to parse pkcs12 key " + err.Error())
+		return
+	}
+
+	der, err = x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		err = errors.New("profile: Failed to encode pkcs12 key " +
+			err.Error())
+		return
+	}
+
+	data = pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	})
+	return
+}
+
+// Convert PKCS#12 bundle to ca, cert and key blocks, client certificate
+// is the one matching the key id
+func convertPkcs12(data []byte, password string) (blocks string,
+	err error) {
+
+	pemBlocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		// openssl 3 defaults to aes with pbes2 which is not supported
+		if _, ok := err.(pkcs12.NotImplementedError); ok {
+			err = errors.New("profile: Pkcs12 encryption not supported, " +
+				"export bundle with openssl pkcs12 -export -legacy " +
+				err.Error())
+		} else if err == pkcs12.ErrIncorrectPassword {
+			err = errors.New("profile: Pkcs12 password incorrect")
+		} else {
+			err = errors.New("profile: Failed to decode pkcs12 " +
+				err.Error())
+		}
+		return
+	}
+
+	keyId := ""
+	var key *pem.Block
+	certs := []*pem.Block{}
+
+	for _, block := range pemBlocks {
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block)
+		} else {
+			key = block
+			keyId = block.Headers["localKeyId"]
+		}
+	}
+
+	if key == nil || len(certs) == 0 {
+		err = errors.New("profile: Pkcs12 missing key or certificate")
+		return
+	}
+
+	certIndex := 0
+	for i, cert := range certs {
+		if keyId != "" && cert.Headers["localKeyId"] == keyId {
+			certIndex = i
+			break
+		}
+	}
+
+	encode := func(block *pem.Block) []byte {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  block.Type,
+			Bytes: block.Bytes,
+		})
+	}
+
+	caData := [][]byte{}
+	for i, cert := range certs {
+		if i != certIndex {
+			caData = append(caData, encode(cert))
+		}
+	}
+
+	parts := []string{}
+	if len(caData) > 0 {
+		parts = append(parts, inlineBlock("ca", bytes.Join(caData, nil)))
+	}
+	parts = append(parts, inlineBlock("cert", encode(certs[certIndex])))
+
+	keyData, err := pkcs8Key(key.Bytes)
+	if err != nil {
+		return
+	}
+	parts = append(parts, inlineBlock("key", keyData))
+
+	blocks = strings.Join(parts, "\n")
+	return
+}
+
+// Inline files referenced by profile data, missing references are
+// returned as ImportError before anything is converted
+func Import(data string, resolve ImportResolver,
+	pkcs12Password string) (result string, err error) {
+
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	files := map[int][]byte{}
+	missing := []*MissingRef{}
+	block := ""
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if block != "" {
+			if trimmed == "</"+block+">" {
+				block = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "<") &&
+			strings.HasSuffix(trimmed, ">") {
+
+			block = trimmed[1 : len(trimmed)-1]
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 || !fileDirectives[fields[0]] ||
+			fields[1] == "[inline]" {
+
+			continue
+		}
+
+		fileData, e := resolve(fields[1])
+		if e != nil {
+			missing = append(missing, &MissingRef{
+				Line:      i + 1,
+				Directive: fields[0],
+				Path:      fields[1],
+			})
+			continue
+		}
+		files[i] = fileData
+	}
+
+	if len(missing) > 0 {
+		err = &ImportError{
+			Missing: missing,
+		}
+		return
+	}
+
+	for i, fileData := range files {
+		fields := strings.Fields(lines[i])
+
+		switch fields[0] {
+		case "pkcs12":
+			lines[i], err = convertPkcs12(fileData, pkcs12Password)
+			if err != nil {
+				return
+			}
+		case "tls-auth":
+			lines[i] = inlineBlock(fields[0], fileData)
+			if len(fields) > 2 {
+				lines[i] = "key-direction " + fields[2] + "\n" + lines[i]
+			}
+		default:
+			lines[i] = inlineBlock(fields[0], fileData)
+		}
+	}
+
+	result = strings.Join(lines, "\n")
+	return
+}
+
+// Append PKCS#12 bundle given separately from profile data
+func ImportPkcs12(data string, bundle []byte, password string) (
+	result string, err error) {
+
+	blocks, err := convertPkcs12(bundle, password)
+	if err != nil {
+		return
+	}
+
+	result = strings.TrimRight(data, "\n") + "\n" + blocks + "\n"
+	return
+}
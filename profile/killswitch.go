@@ -0,0 +1,602 @@
+package profile
+
+import (
+	"../shared/command"
+	"../shared/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	killSwitchTable = "pritunl"
+)
+
+var (
+	killSwitches = struct {
+		sync.Mutex
+		m map[string]*killSwitch
+	}{
+		m: map[string]*killSwitch{},
+	}
+	// last resolved addresses of remote hosts, used when dns is blocked by
+	// installed rules
+	resolvedHosts = struct {
+		sync.Mutex
+		m map[string][]string
+	}{}
+	// systemd-resolved stub forwards to servers of second file
+	resolvConfPaths = []string{
+		"/etc/resolv.conf",
+		"/run/systemd/resolve/resolv.conf",
+	}
+)
+
+type killEndpoint struct {
+	host  string
+	addrs []string
+	port  string
+	proto string
+}
+
+// Allowed traffic of profile with kill switch enabled, tunnel interface is
+// empty until device of connection is known
+type killSwitch struct {
+	intf      string
+	endpoints []*killEndpoint
+}
+
+// Tunnel interface of profile data, empty for dynamic tun and tap devices
+// which are named by openvpn on connect
+func tunnelIntf(conf *Config) string {
+	dev := "tun"
+	if drct := conf.Last("dev"); drct != nil && len(drct.Args) > 0 {
+		dev = drct.Args[0]
+	}
+
+	if dev == "tun" || dev == "tap" {
+		return ""
+	}
+	return dev
+}
+
+func resolvedPath() (pth string, err error) {
+	dataDir, err := utils.GetDataDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(dataDir, "resolved.json")
+	return
+}
+
+// Resolve remote host, last resolved addresses are used on failure
+func resolveRemote(host string) (addrs []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+	cancel()
+
+	resolvedHosts.Lock()
+	defer resolvedHosts.Unlock()
+
+	pth, e := resolvedPath()
+	if resolvedHosts.m == nil {
+		resolvedHosts.m = map[string][]string{}
+		if e == nil {
+			if data, e := ioutil.ReadFile(pth); e == nil {
+				json.Unmarshal(data, &resolvedHosts.m)
+			}
+		}
+	}
+
+	if err != nil {
+		if cached := resolvedHosts.m[host]; len(cached) > 0 {
+			log.Warning("profile: Kill switch using cached addresses",
+				host, err)
+			addrs = cached
+			err = nil
+		}
+		return
+	}
+
+	resolvedHosts.m[host] = addrs
+	if e == nil {
+		if data, e := json.Marshal(resolvedHosts.m); e == nil {
+			ioutil.WriteFile(pth, data, os.FileMode(0600))
+		}
+	}
+
+	return
+}
+
+// Remotes of profile including <connection> blocks, options missing in
+// connection block default to global port and proto
+func killRemotes(data string) (remotes []*RemoteProbe) {
+	remotes = parseRemoteConf(data).remotes
+
+	conf, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	port := defaultPort
+	if drct := conf.Last("port"); drct != nil && len(drct.Args) > 0 {
+		port = drct.Args[0]
+	}
+	proto := defaultProto
+	if drct := conf.Last("proto"); drct != nil && len(drct.Args) > 0 {
+		proto = drct.Args[0]
+	}
+
+	for _, block := range conf.Blocks {
+		if block.Name != "connection" {
+			continue
+		}
+
+		blockConf, e := Parse(block.Data)
+		if e != nil {
+			continue
+		}
+
+		blockPort := port
+		if drct := blockConf.Last("port"); drct != nil &&
+			len(drct.Args) > 0 {
+
+			blockPort = drct.Args[0]
+		}
+		blockProto := proto
+		if drct := blockConf.Last("proto"); drct != nil &&
+			len(drct.Args) > 0 {
+
+			blockProto = drct.Args[0]
+		}
+
+		for _, drct := range blockConf.Get("remote") {
+			if len(drct.Args) < 1 {
+				continue
+			}
+
+			remote := &RemoteProbe{
+				Host:  drct.Args[0],
+				Port:  blockPort,
+				Proto: blockProto,
+				// block data starts on line after <connection>
+				line: block.Line + drct.Line - 1,
+			}
+			if len(drct.Args) > 1 {
+				remote.Port = drct.Args[1]
+			}
+			if len(drct.Args) > 2 {
+				remote.Proto = drct.Args[2]
+			}
+			remotes = append(remotes, remote)
+		}
+	}
+
+	return
+}
+
+func newKillSwitch(data string) (ks *killSwitch, err error) {
+	conf, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	ks = &killSwitch{
+		intf:      tunnelIntf(conf),
+		endpoints: []*killEndpoint{},
+	}
+
+	for _, remote := range killRemotes(data) {
+		endpoint := &killEndpoint{
+			host:  remote.Host,
+			port:  remote.Port,
+			proto: "udp",
+		}
+		if isTcp(remote.Proto) {
+			endpoint.proto = "tcp"
+		}
+
+		addrs, e := resolveRemote(remote.Host)
+		if e != nil {
+			log.Warning("profile: Kill switch failed to resolve remote",
+				remote.Host, e)
+			continue
+		}
+		endpoint.addrs = addrs
+
+		ks.endpoints = append(ks.endpoints, endpoint)
+	}
+
+	if len(ks.endpoints) == 0 {
+		ks = nil
+		err = errors.New("profile: Kill switch failed to resolve remotes")
+		return
+	}
+
+	return
+}
+
+// Rewrite remote hosts to addresses allowed by kill switch
+func (ks *killSwitch) pinRemotes(data string) string {
+	resolved := map[string][]string{}
+	for _, endpoint := range ks.endpoints {
+		resolved[endpoint.host] = endpoint.addrs
+	}
+
+	topLevel := map[int]bool{}
+	for _, remote := range parseRemoteConf(data).remotes {
+		topLevel[remote.line] = true
+	}
+
+	lines := strings.Split(data, "\n")
+	for _, remote := range killRemotes(data) {
+		addrs := resolved[remote.Host]
+		if len(addrs) == 0 || net.ParseIP(remote.Host) != nil {
+			continue
+		}
+
+		// connection block has single remote
+		if !topLevel[remote.line] {
+			addrs = addrs[:1]
+		}
+
+		pinned := []string{}
+		for _, addr := range addrs {
+			pinned = append(pinned, strings.Join([]string{
+				"remote", addr, remote.Port, remote.Proto}, " "))
+		}
+		lines[remote.line] = strings.Join(pinned, "\n")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func nftQuote(val string) string {
+	return "\"" + strings.Replace(val, "\"", "", -1) + "\""
+}
+
+// Build ruleset dropping everything except loopback, dhcp, tunnel
+// interfaces and remote endpoints of all kill switch profiles
+func killSwitchRules(switches []*killSwitch) string {
+	input := []string{
+		"iifname \"lo\" accept",
+		"ct state established,related ip saddr @remotes accept",
+		"ct state established,related ip6 saddr @remotes6 accept",
+		"udp sport 67 udp dport 68 accept",
+		"udp sport 547 udp dport 546 accept",
+		"icmpv6 type { nd-router-advert, nd-neighbor-solicit, " +
+			"nd-neighbor-advert } accept",
+	}
+	output := []string{
+		"oifname \"lo\" accept",
+		"udp sport 68 udp dport 67 accept",
+		"udp sport 546 udp dport 547 accept",
+		"icmpv6 type { nd-router-solicit, nd-neighbor-solicit, " +
+			"nd-neighbor-advert } accept",
+	}
+	remotes := []string{}
+	remotes6 := []string{}
+	intfs := map[string]bool{}
+	seen := map[string]bool{}
+
+	for _, ks := range switches {
+		if ks.intf != "" {
+			intfs[ks.intf] = true
+		}
+
+		for _, endpoint := range ks.endpoints {
+			for _, addr := range endpoint.addrs {
+				ip := net.ParseIP(addr)
+				if ip == nil {
+					continue
+				}
+
+				family := "ip"
+				if ip.To4() == nil {
+					family = "ip6"
+				}
+
+				// set elements must be unique
+				if !seen[addr] {
+					seen[addr] = true
+					if family == "ip6" {
+						remotes6 = append(remotes6, addr)
+					} else {
+						remotes = append(remotes, addr)
+					}
+				}
+
+				output = append(output, family+" daddr "+addr+" "+
+					endpoint.proto+" dport "+endpoint.port+" accept")
+			}
+		}
+	}
+
+	intfNames := []string{}
+	for intf := range intfs {
+		intfNames = append(intfNames, intf)
+	}
+	sort.Strings(intfNames)
+
+	for _, intf := range intfNames {
+		input = append(input, "iifname "+nftQuote(intf)+" accept")
+		output = append(output, "oifname "+nftQuote(intf)+" accept")
+	}
+
+	rules := &bytes.Buffer{}
+	rules.WriteString("table inet " + killSwitchTable + " {}\n")
+	rules.WriteString("delete table inet " + killSwitchTable + "\n")
+	rules.WriteString("table inet " + killSwitchTable + " {\n")
+
+	rules.WriteString("\tset remotes {\n\t\ttype ipv4_addr\n")
+	if len(remotes) > 0 {
+		rules.WriteString("\t\telements = { " +
+			strings.Join(remotes, ", ") + " }\n")
+	}
+	rules.WriteString("\t}\n")
+
+	rules.WriteString("\tset remotes6 {\n\t\ttype ipv6_addr\n")
+	if len(remotes6) > 0 {
+		rules.WriteString("\t\telements = { " +
+			strings.Join(remotes6, ", ") + " }\n")
+	}
+	rules.WriteString("\t}\n")
+
+	for _, chain := range []struct {
+		name  string
+		rules []string
+	}{
+		{"input", input},
+		{"output", output},
+		{"forward", []string{}},
+	} {
+		rules.WriteString("\tchain " + chain.name + " {\n")
+		rules.WriteString("\t\ttype filter hook " + chain.name +
+			" priority 0; policy drop;\n")
+		for _, rule := range chain.rules {
+			rules.WriteString("\t\t" + rule + "\n")
+		}
+		rules.WriteString("\t}\n")
+	}
+
+	rules.WriteString("}\n")
+
+	return rules.String()
+}
+
+func nft(rules string) (err error) {
+	cmd := command.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(rules)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		err = errors.New("profile: Failed to apply nftables rules " +
+			strings.TrimSpace(string(output)))
+		return
+	}
+
+	return
+}
+
+// Apply table for current kill switches, table is removed when none left
+// unless always-on mode keeps lockdown rules allowing only loopback and dhcp
+func applyKillSwitches() (err error) {
+	switches := []*killSwitch{}
+	for _, ks := range killSwitches.m {
+		switches = append(switches, ks)
+	}
+
+	if len(switches) == 0 && !IsAlwaysOn("") {
+		err = nft("table inet " + killSwitchTable + " {}\n" +
+			"delete table inet " + killSwitchTable + "\n")
+		return
+	}
+
+	// resolvers stay reachable until remotes of always-on profile are
+	// resolved, otherwise lockdown blocks its first connect
+	if id := GetAlwaysOn(); IsAlwaysOn(id) && killSwitches.m[id] == nil {
+		if ks := resolverKillSwitch(); ks != nil {
+			switches = append(switches, ks)
+		}
+	}
+
+	err = nft(killSwitchRules(switches))
+	return
+}
+
+// Nameservers of system resolver configuration excluding loopback
+func systemResolvers() (addrs []string) {
+	seen := map[string]bool{}
+
+	for _, pth := range resolvConfPaths {
+		data, err := ioutil.ReadFile(pth)
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+
+			addr := strings.SplitN(fields[1], "%", 2)[0]
+			ip := net.ParseIP(addr)
+			if ip == nil || ip.IsLoopback() || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return
+}
+
+// Allowed dns traffic to system resolvers, nil without resolvers
+func resolverKillSwitch() (ks *killSwitch) {
+	addrs := systemResolvers()
+	if len(addrs) == 0 {
+		return
+	}
+
+	ks = &killSwitch{
+		endpoints: []*killEndpoint{},
+	}
+	for _, proto := range []string{"udp", "tcp"} {
+		ks.endpoints = append(ks.endpoints, &killEndpoint{
+			host:  "nameserver",
+			addrs: addrs,
+			port:  "53",
+			proto: proto,
+		})
+	}
+
+	return
+}
+
+// Enable kill switch for profile, remotes are resolved again on each start
+// and existing rules of profile are kept until replaced so traffic stays
+// blocked between reconnects
+func (p *Profile) enableKillSwitch() (ks *killSwitch, err error) {
+	if runtime.GOOS != "linux" {
+		err = errors.New("profile: Kill switch not supported on " +
+			runtime.GOOS)
+		return
+	}
+
+	killSwitches.Lock()
+	defer killSwitches.Unlock()
+
+	prev := killSwitches.m[p.Id]
+
+	ks, err = newKillSwitch(p.Data)
+	if err != nil {
+		return
+	}
+
+	killSwitches.m[p.Id] = ks
+
+	err = applyKillSwitches()
+	if err != nil {
+		if prev != nil {
+			killSwitches.m[p.Id] = prev
+		} else {
+			delete(killSwitches.m, p.Id)
+		}
+		ks = nil
+		return
+	}
+
+	log.Info("profile: Kill switch enabled", p.Id)
+
+	return
+}
+
+// Allow traffic on tunnel device of connected profile
+func (p *Profile) setKillSwitchIntf(dev string) {
+	if dev == "" {
+		return
+	}
+
+	killSwitches.Lock()
+	defer killSwitches.Unlock()
+
+	ks := killSwitches.m[p.Id]
+	if ks == nil || ks.intf == dev {
+		return
+	}
+
+	prev := ks.intf
+	ks.intf = dev
+
+	err := applyKillSwitches()
+	if err != nil {
+		ks.intf = prev
+		log.Error("profile: Failed to allow kill switch interface", err)
+		return
+	}
+}
+
+// Block all traffic while always-on profile is down, installed before
+// remotes are resolved so traffic is never open during startup. Only dns
+// to system resolvers is allowed until remotes are resolved.
+func LockdownKillSwitches() {
+	if runtime.GOOS != "linux" || !IsAlwaysOn("") {
+		return
+	}
+
+	killSwitches.Lock()
+	defer killSwitches.Unlock()
+
+	err := applyKillSwitches()
+	if err != nil {
+		log.Error("profile: Failed to enable always-on lockdown", err)
+		return
+	}
+
+	log.Info("profile: Always-on lockdown enabled")
+}
+
+// Remove kill switch of profile after explicit stop, always-on profile
+// stays locked down
+func DisableKillSwitch(id string) {
+	if IsAlwaysOn(id) {
+		return
+	}
+
+	killSwitches.Lock()
+	defer killSwitches.Unlock()
+
+	if _, ok := killSwitches.m[id]; !ok {
+		return
+	}
+	delete(killSwitches.m, id)
+
+	err := applyKillSwitches()
+	if err != nil {
+		log.Error("profile: Failed to disable kill switch", err)
+		return
+	}
+
+	log.Info("profile: Kill switch disabled", id)
+}
+
+// Remove kill switch rules except always-on profile, also clears table
+// left by previous run. Table is replaced with lockdown rules instead of
+// flushed in always-on mode.
+func DisableKillSwitches() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	if _, err := exec.LookPath("nft"); err != nil {
+		return
+	}
+
+	killSwitches.Lock()
+	defer killSwitches.Unlock()
+
+	switches := map[string]*killSwitch{}
+	if id := GetAlwaysOn(); IsAlwaysOn(id) && killSwitches.m[id] != nil {
+		switches[id] = killSwitches.m[id]
+	}
+	killSwitches.m = switches
+
+	err := applyKillSwitches()
+	if err != nil {
+		log.Error("profile: Failed to disable kill switches", err)
+	}
+}
@@ -0,0 +1,293 @@
+package profile
+
+import (
+	"../shared/utils"
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mgmtWriteTimeout  = 5 * time.Second
+	mgmtBytecountSecs = 5
+)
+
+// Client for OpenVPN management interface. OpenVPN connects to listener
+// created for each profile with --management-client and waits in hold
+// until released.
+type management struct {
+	prfl     *Profile
+	ln       net.Listener
+	conn     net.Conn
+	connLock sync.Mutex
+	sockPath string
+}
+
+// Escape value for management command
+func mgmtQuote(val string) string {
+	val = strings.Replace(val, "\\", "\\\\", -1)
+	val = strings.Replace(val, "\"", "\\\"", -1)
+	return "\"" + val + "\""
+}
+
+func newManagement(p *Profile) (mgmt *management, args []string,
+	err error) {
+
+	mgmt = &management{
+		prfl: p,
+	}
+
+	if runtime.GOOS == "windows" {
+		mgmt.ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			err = errors.New("profile: Failed to listen management " +
+				err.Error())
+			return
+		}
+
+		addr := mgmt.ln.Addr().(*net.TCPAddr)
+		args = []string{
+			"--management", "127.0.0.1", strconv.Itoa(addr.Port),
+		}
+	} else {
+		rootDir, e := utils.GetTempDir()
+		if e != nil {
+			err = e
+			return
+		}
+
+		mgmt.sockPath = filepath.Join(rootDir, p.Id+".sock")
+		os.Remove(mgmt.sockPath)
+
+		mgmt.ln, err = net.Listen("unix", mgmt.sockPath)
+		if err != nil {
+			err = errors.New("profile: Failed to listen management " +
+				err.Error())
+			return
+		}
+
+		err = os.Chmod(mgmt.sockPath, 0600)
+		if err != nil {
+			mgmt.ln.Close()
+			err = errors.New("profile: Failed to chmod management " +
+				err.Error())
+			return
+		}
+
+		args = []string{
+			"--management", mgmt.sockPath, "unix",
+		}
+	}
+
+	args = append(args,
+		"--management-client",
+		"--management-hold",
+		"--management-query-passwords",
+	)
+
+	return
+}
+
+func (m *management) run() {
+	conn, err := m.ln.Accept()
+	m.ln.Close()
+	if err != nil {
+		return
+	}
+
+	m.connLock.Lock()
+	m.conn = conn
+	m.connLock.Unlock()
+
+	for _, cmd := range []string{
+		"state on",
+		"bytecount " + strconv.Itoa(mgmtBytecountSecs),
+		"hold release",
+	} {
+		err = m.send(cmd)
+		if err != nil {
+			log.Error("profile: Management error", err)
+			return
+		}
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line != "" {
+			m.handleLine(line)
+		}
+	}
+}
+
+func (m *management) send(cmd string) (err error) {
+	m.connLock.Lock()
+	defer m.connLock.Unlock()
+
+	if m.conn == nil {
+		err = errors.New("profile: Management not connected")
+		return
+	}
+
+	m.conn.SetWriteDeadline(time.Now().Add(mgmtWriteTimeout))
+	_, err = m.conn.Write([]byte(cmd + "\n"))
+	if err != nil {
+		err = errors.New("profile: Failed to write management " +
+			err.Error())
+		return
+	}
+
+	return
+}
+
+func (m *management) close() {
+	m.ln.Close()
+
+	m.connLock.Lock()
+	if m.conn != nil {
+		m.conn.Close()
+	}
+	m.connLock.Unlock()
+
+	if m.sockPath != "" {
+		os.Remove(m.sockPath)
+	}
+}
+
+func (m *management) handleLine(line string) {
+	p := m.prfl
+
+	switch {
+	case strings.HasPrefix(line, ">STATE:"):
+		fields := strings.Split(line[7:], ",")
+		for len(fields) < 9 {
+			fields = append(fields, "")
+		}
+		p.parseState(fields[1], fields[2], fields[3], fields[4])
+	case strings.HasPrefix(line, ">BYTECOUNT:"):
+		fields := strings.Split(line[11:], ",")
+		if len(fields) == 2 {
+			bytesIn, _ := strconv.ParseUint(fields[0], 10, 64)
+			bytesOut, _ := strconv.ParseUint(fields[1], 10, 64)
+			p.parseBytecount(bytesIn, bytesOut)
+		}
+	case strings.HasPrefix(line, ">PASSWORD:"):
+		m.handlePassword(line[10:])
+	case strings.HasPrefix(line, ">HOLD:"):
+		err := m.send("hold release")
+		if err != nil {
+			log.Error("profile: Management error", err)
+		}
+	case strings.HasPrefix(line, ">FATAL:"):
+		log.Error("profile: OpenVPN fatal error", p.Id, line[7:])
+		p.pushOutput(line[7:])
+	case strings.HasPrefix(line, "ERROR:"):
+		log.Warning("profile: Management command error", p.Id, line)
+	}
+}
+
+func (m *management) sendAuth(username, password string) (err error) {
+	err = m.send("username \"Auth\" " + mgmtQuote(username))
+	if err != nil {
+		return
+	}
+
+	err = m.send("password \"Auth\" " + mgmtQuote(password))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (m *management) sendKeyPassphrase(passphrase string) (err error) {
+	err = m.send("password \"Private Key\" " + mgmtQuote(passphrase))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (m *management) handlePassword(msg string) {
+	p := m.prfl
+
+	if strings.HasPrefix(msg, "Verification Failed: 'Private Key'") {
+		log.Error("profile: Private key passphrase failed", p.Id)
+		p.keyPassphraseFailed()
+		go p.Stop()
+		return
+	}
+
+	if strings.HasPrefix(msg, "Verification Failed") {
+		if chal := parseDynamicChallenge(msg); chal != nil {
+			p.startChallenge(chal)
+			return
+		}
+
+		// openvpn keeps asking with auth-retry interact
+		p.authFailed()
+		go p.Stop()
+		return
+	}
+
+	if strings.HasPrefix(msg, "Need 'Private Key'") {
+		if p.KeyPassphrase == "" && !p.loadSessionCredentials(PromptKey) {
+			log.Info("profile: Private key passphrase required", p.Id)
+			p.promptCredentials(PromptKey)
+			return
+		}
+
+		err := m.sendKeyPassphrase(p.KeyPassphrase)
+		if err != nil {
+			log.Error("profile: Management error", err)
+		}
+		return
+	}
+
+	if !strings.HasPrefix(msg, "Need 'Auth'") {
+		log.Warning("profile: Unsupported password request", p.Id, msg)
+		return
+	}
+
+	if p.challengeNeedAuth() {
+		return
+	}
+
+	if chal := parseStaticChallenge(msg); chal != nil {
+		p.startChallenge(chal)
+		return
+	}
+
+	if p.Username == "" && p.Password == "" && p.ServerPublicKey == "" &&
+		!p.loadSessionCredentials(PromptAuth) {
+
+		log.Info("profile: Credentials required", p.Id)
+		p.promptCredentials(PromptAuth)
+		return
+	}
+
+	password, err := p.authPassword()
+	if err != nil {
+		log.Error("profile: Failed to get auth password", err)
+		go p.Stop()
+		return
+	}
+
+	err = m.sendAuth(p.Username, password)
+	if err != nil {
+		log.Error("profile: Management error", err)
+	}
+}
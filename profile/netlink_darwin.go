@@ -0,0 +1,11 @@
+package profile
+
+const (
+	netlinkSupported = false
+)
+
+func installNetTable(table *NetTable) {
+}
+
+func removeNetTable(table *NetTable) {
+}
@@ -0,0 +1,172 @@
+package profile
+
+import (
+	"github.com/vishvananda/netlink"
+	"net"
+)
+
+const (
+	netlinkSupported = true
+)
+
+func netlinkRoute(route *NetRoute, link netlink.Link) (
+	nlRoute *netlink.Route, err error) {
+
+	_, dst, err := net.ParseCIDR(route.Network)
+	if err != nil {
+		return
+	}
+
+	nlRoute = &netlink.Route{
+		Dst:      dst,
+		Priority: route.Metric,
+	}
+	if link != nil {
+		nlRoute.LinkIndex = link.Attrs().Index
+	}
+	if route.Gateway != "" {
+		nlRoute.Gw = net.ParseIP(route.Gateway)
+	}
+
+	return
+}
+
+// Lookup links of routes once, missing links are nil
+func routeLinks(table *NetTable) (links map[string]netlink.Link) {
+	links = map[string]netlink.Link{}
+
+	for _, route := range table.Routes {
+		if route.Dev == "" {
+			continue
+		}
+		if _, ok := links[route.Dev]; ok {
+			continue
+		}
+
+		link, err := netlink.LinkByName(route.Dev)
+		if err != nil {
+			links[route.Dev] = nil
+			continue
+		}
+		links[route.Dev] = link
+	}
+
+	return
+}
+
+// Configure tunnel interface and add routes, failures are recorded on
+// entries of table
+func installNetTable(table *NetTable) {
+	link, err := netlink.LinkByName(table.Dev)
+	if err != nil {
+		for _, addr := range table.Addresses {
+			addr.Error = err.Error()
+		}
+	} else {
+		if table.Mtu > 0 {
+			err = netlink.LinkSetMTU(link, table.Mtu)
+			if err != nil {
+				log.Warning("profile: Failed to set mtu", table.Dev, err)
+			}
+		}
+
+		err = netlink.LinkSetUp(link)
+		if err != nil {
+			log.Warning("profile: Failed to set link up", table.Dev, err)
+		}
+
+		for _, addr := range table.Addresses {
+			nlAddr, e := netlink.ParseAddr(addr.Address)
+			if e != nil {
+				addr.Error = e.Error()
+				continue
+			}
+
+			if addr.Peer != "" {
+				nlAddr.Peer, e = netlink.ParseIPNet(addr.Peer)
+				if e != nil {
+					addr.Error = e.Error()
+					continue
+				}
+			}
+
+			e = netlink.AddrReplace(link, nlAddr)
+			if e != nil {
+				addr.Error = e.Error()
+			}
+		}
+	}
+
+	links := routeLinks(table)
+
+	for _, route := range table.Routes {
+		var routeLink netlink.Link
+		if route.Dev != "" {
+			routeLink = links[route.Dev]
+			if routeLink == nil {
+				route.Error = "link " + route.Dev + " not found"
+				continue
+			}
+		}
+
+		nlRoute, e := netlinkRoute(route, routeLink)
+		if e != nil {
+			route.Error = e.Error()
+			continue
+		}
+
+		e = netlink.RouteReplace(nlRoute)
+		if e != nil {
+			route.Error = e.Error()
+		}
+	}
+}
+
+// Delete routes and addresses of table, entries of removed tunnel
+// interface are already gone
+func removeNetTable(table *NetTable) {
+	links := routeLinks(table)
+
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			continue
+		}
+
+		var routeLink netlink.Link
+		if route.Dev != "" {
+			routeLink = links[route.Dev]
+			if routeLink == nil {
+				continue
+			}
+		}
+
+		nlRoute, e := netlinkRoute(route, routeLink)
+		if e == nil {
+			e = netlink.RouteDel(nlRoute)
+		}
+		if e != nil {
+			log.Warning("profile: Failed to remove route",
+				route.Network, e)
+		}
+	}
+
+	link, err := netlink.LinkByName(table.Dev)
+	if err != nil {
+		return
+	}
+
+	for _, addr := range table.Addresses {
+		if addr.Error != "" {
+			continue
+		}
+
+		nlAddr, e := netlink.ParseAddr(addr.Address)
+		if e == nil {
+			e = netlink.AddrDel(link, nlAddr)
+		}
+		if e != nil {
+			log.Warning("profile: Failed to remove address",
+				addr.Address, e)
+		}
+	}
+}
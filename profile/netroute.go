@@ -0,0 +1,233 @@
+package profile
+
+import (
+	"../shared/utils"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	RouteServer = "server"
+)
+
+// Address installed on tunnel interface, address is in CIDR notation
+type NetAddr struct {
+	Address string `json:"address"`
+	Peer    string `json:"peer,omitempty"`
+	Dev     string `json:"dev"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Route installed by daemon, empty dev routes through gateway
+type NetRoute struct {
+	Network string `json:"network"`
+	Gateway string `json:"gateway,omitempty"`
+	Dev     string `json:"dev,omitempty"`
+	Metric  int    `json:"metric,omitempty"`
+	Source  string `json:"source"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Addresses and routes daemon installed for profile
+type NetTable struct {
+	Dev       string      `json:"dev"`
+	Mtu       int         `json:"mtu,omitempty"`
+	Addresses []*NetAddr  `json:"addresses"`
+	Routes    []*NetRoute `json:"routes"`
+}
+
+func newNetTable(env *routeEnv) (table *NetTable) {
+	table = &NetTable{
+		Dev:       env.dev,
+		Mtu:       env.mtu,
+		Addresses: []*NetAddr{},
+		Routes:    []*NetRoute{},
+	}
+	tun := strings.HasPrefix(env.dev, "tun")
+
+	if env.local != "" {
+		addr := &NetAddr{
+			Dev: env.dev,
+		}
+
+		if env.remote != "" {
+			// point to point topology
+			addr.Address = env.local + "/32"
+			addr.Peer = env.remote + "/32"
+		} else if cidr := maskCidr(env.local, env.netmask); cidr != "" {
+			addr.Address = env.local + cidr[strings.Index(cidr, "/"):]
+		}
+
+		if addr.Address != "" {
+			table.Addresses = append(table.Addresses, addr)
+		}
+	}
+
+	if env.local6 != "" && env.netbits6 > 0 {
+		table.Addresses = append(table.Addresses, &NetAddr{
+			Address: env.local6 + "/" + strconv.Itoa(env.netbits6),
+			Dev:     env.dev,
+		})
+	}
+
+	if env.redirect && env.trustedIp != "" && env.netGateway != "" {
+		table.Routes = append(table.Routes, &NetRoute{
+			Network: env.trustedIp + "/32",
+			Gateway: env.netGateway,
+			Source:  RouteServer,
+		})
+	}
+
+	for _, route := range env.routes {
+		netRoute := &NetRoute{
+			Network: route.Network,
+			Metric:  route.Metric,
+			Source:  route.Source,
+		}
+
+		if route.Gateway != "" && route.Gateway == env.netGateway {
+			netRoute.Gateway = route.Gateway
+		} else {
+			netRoute.Dev = env.dev
+			// tun routes point at device, tap routes need gateway
+			if !tun {
+				netRoute.Gateway = route.Gateway
+			}
+		}
+
+		table.Routes = append(table.Routes, netRoute)
+	}
+
+	return
+}
+
+func netTablePath(id string) (pth string, err error) {
+	rootDir, err := utils.GetTempDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(rootDir, id+"-routes.json")
+	return
+}
+
+// Persist installed table so routes can be removed after daemon crash
+func saveNetTable(id string, table *NetTable) {
+	pth, err := netTablePath(id)
+	if err != nil {
+		log.Error("profile: Failed to save routes", err)
+		return
+	}
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		log.Error("profile: Failed to save routes", err)
+		return
+	}
+
+	err = ioutil.WriteFile(pth, data, os.FileMode(0600))
+	if err != nil {
+		log.Error("profile: Failed to save routes", err)
+		return
+	}
+}
+
+// Install addresses and routes openvpn pushed, openvpn only computes
+// them with route-noexec and ifconfig-noexec so missing route up settings
+// leave tunnel unconfigured
+func (p *Profile) applyNetTable() (err error) {
+	if !netlinkSupported {
+		return
+	}
+
+	if p.routeEnv == nil {
+		err = errors.New("profile: Missing route up settings, " +
+			"tunnel not configured")
+		return
+	}
+
+	// routes of previous connection are replaced after restart
+	p.removeNetTable()
+
+	table := newNetTable(p.routeEnv)
+	// saved before install to clean partially installed table after crash
+	saveNetTable(p.Id, table)
+
+	installNetTable(table)
+	saveNetTable(p.Id, table)
+
+	p.statusLock.Lock()
+	p.netTable = table
+	p.statusLock.Unlock()
+
+	for _, route := range table.Routes {
+		if route.Error != "" {
+			log.Warning("profile: Failed to add route", p.Id,
+				route.Network, route.Error)
+		}
+	}
+
+	return
+}
+
+// Remove installed addresses and routes after openvpn exits
+func (p *Profile) removeNetTable() {
+	p.statusLock.Lock()
+	table := p.netTable
+	p.netTable = nil
+	p.statusLock.Unlock()
+
+	if table == nil {
+		return
+	}
+
+	removeNetTable(table)
+
+	if pth, err := netTablePath(p.Id); err == nil {
+		os.Remove(pth)
+	}
+}
+
+// Get addresses and routes installed for profile
+func (p *Profile) GetNetTable() (table *NetTable) {
+	p.statusLock.Lock()
+	table = p.netTable
+	p.statusLock.Unlock()
+
+	if table == nil {
+		table = &NetTable{
+			Addresses: []*NetAddr{},
+			Routes:    []*NetRoute{},
+		}
+	}
+	return
+}
+
+// Remove routes left by previous run
+func CleanNetTables() {
+	if !netlinkSupported {
+		return
+	}
+
+	rootDir, err := utils.GetTempDir()
+	if err != nil {
+		return
+	}
+
+	pths, _ := filepath.Glob(filepath.Join(rootDir, "*-routes.json"))
+	for _, pth := range pths {
+		data, err := ioutil.ReadFile(pth)
+		if err == nil {
+			table := &NetTable{}
+			if json.Unmarshal(data, table) == nil {
+				removeNetTable(table)
+			}
+		}
+		os.Remove(pth)
+	}
+}
@@ -0,0 +1,229 @@
+package profile
+
+import (
+	"../shared/command"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	versionRe  = regexp.MustCompile(`OpenVPN (\d+)\.(\d+)\.(\d+)\S*`)
+	featureRe  = regexp.MustCompile(`\[([^\]]+)\]`)
+	openvpn    *OpenvpnInfo
+	openvpnMux sync.Mutex
+	// minimum openvpn version of directives
+	directiveVersions = map[string][2]int{
+		"allow-compression":     {2, 5},
+		"block-ipv6":            {2, 5},
+		"data-ciphers":          {2, 5},
+		"data-ciphers-fallback": {2, 5},
+		"disable-dco":           {2, 6},
+		"dns":                   {2, 6},
+		"peer-fingerprint":      {2, 6},
+		"tls-cert-profile":      {2, 4},
+		"tls-crypt":             {2, 4},
+		"tls-crypt-v2":          {2, 5},
+		"tls-ciphersuites":      {2, 4},
+	}
+)
+
+// Installed openvpn binary, version is zero when binary is missing
+type OpenvpnInfo struct {
+	Path       string   `json:"path"`
+	Found      bool     `json:"found"`
+	Version    string   `json:"version"`
+	Major      int      `json:"major"`
+	Minor      int      `json:"minor"`
+	Patch      int      `json:"patch"`
+	SslLibrary string   `json:"ssl_library"`
+	Features   []string `json:"features"`
+	Error      string   `json:"error,omitempty"`
+}
+
+func (o *OpenvpnInfo) AtLeast(major, minor int) bool {
+	return o.Major > major || (o.Major == major && o.Minor >= minor)
+}
+
+func (o *OpenvpnInfo) HasFeature(feature string) bool {
+	for _, f := range o.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Candidate binary paths from openvpnPaths config, default path is used
+// when not configured
+func getOpenvpnPaths() (pths []string) {
+	for _, pth := range strings.Split(config.Local.Get("openvpnPaths"), ",") {
+		pth = strings.TrimSpace(pth)
+		if pth != "" {
+			pths = append(pths, pth)
+		}
+	}
+
+	if len(pths) == 0 {
+		pths = []string{getOpenvpnPath()}
+	}
+
+	return
+}
+
+func findOpenvpn() (pth string, err error) {
+	pths := getOpenvpnPaths()
+
+	for _, candidate := range pths {
+		if !strings.ContainsRune(candidate, os.PathSeparator) {
+			found, e := exec.LookPath(candidate)
+			if e == nil {
+				pth = found
+				return
+			}
+			continue
+		}
+
+		if info, e := os.Stat(candidate); e == nil && !info.IsDir() {
+			pth = candidate
+			return
+		}
+	}
+
+	err = errors.New("profile: OpenVPN binary not found in " +
+		strings.Join(pths, ", "))
+	return
+}
+
+func parseOpenvpnVersion(info *OpenvpnInfo, output string) {
+	lines := strings.Split(output, "\n")
+
+	match := versionRe.FindStringSubmatch(lines[0])
+	if match == nil {
+		info.Error = "unknown_version"
+		return
+	}
+
+	info.Version = strings.TrimPrefix(match[0], "OpenVPN ")
+	info.Major, _ = strconv.Atoi(match[1])
+	info.Minor, _ = strconv.Atoi(match[2])
+	info.Patch, _ = strconv.Atoi(match[3])
+
+	for _, feature := range featureRe.FindAllStringSubmatch(lines[0], -1) {
+		info.Features = append(info.Features, feature[1])
+	}
+
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "library versions:") {
+			libs := strings.TrimPrefix(line, "library versions:")
+			info.SslLibrary = strings.TrimSpace(
+				strings.Split(libs, ",")[0])
+			break
+		}
+	}
+}
+
+// Find openvpn binary and parse output of --version
+func ProbeOpenvpn() (info *OpenvpnInfo) {
+	info = &OpenvpnInfo{
+		Features: []string{},
+	}
+
+	pth, err := findOpenvpn()
+	if err != nil {
+		info.Error = "not_found"
+		log.Error("profile: OpenVPN probe failed", err)
+	} else {
+		info.Path = pth
+		info.Found = true
+
+		// older versions exit with status 1 after printing version
+		output, _ := command.Command(pth, "--version").Output()
+		parseOpenvpnVersion(info, string(output))
+
+		if info.Error != "" {
+			log.Error("profile: Failed to detect openvpn version", pth)
+		} else {
+			log.Info("profile: OpenVPN", info.Path, info.Version,
+				info.SslLibrary)
+		}
+	}
+
+	openvpnMux.Lock()
+	openvpn = info
+	openvpnMux.Unlock()
+
+	return
+}
+
+// Get probed openvpn, missing binary is probed again
+func GetOpenvpn() (info *OpenvpnInfo) {
+	openvpnMux.Lock()
+	info = openvpn
+	openvpnMux.Unlock()
+
+	if info == nil || !info.Found {
+		info = ProbeOpenvpn()
+	}
+
+	return
+}
+
+// Check installed openvpn supports directives of profile
+func checkOpenvpn(info *OpenvpnInfo, data string) (err error) {
+	if !info.Found {
+		err = errors.New("profile: OpenVPN binary not found in " +
+			strings.Join(getOpenvpnPaths(), ", "))
+		return
+	}
+
+	// unknown version is not checked
+	if info.Major == 0 {
+		return
+	}
+
+	conf, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	for _, drct := range conf.Directives {
+		if ver, ok := directiveVersions[drct.Name]; ok &&
+			!info.AtLeast(ver[0], ver[1]) {
+
+			err = errors.New("profile: OpenVPN " + info.Version +
+				" too old for " + drct.Name + " on line " +
+				strconv.Itoa(drct.Line) + ", requires " +
+				strconv.Itoa(ver[0]) + "." + strconv.Itoa(ver[1]))
+			return
+		}
+
+		feature := ""
+		switch {
+		case drct.Name == "comp-lzo":
+			feature = "LZO"
+		case drct.Name == "compress" && len(drct.Args) > 0 &&
+			strings.HasPrefix(drct.Args[0], "lz4"):
+			feature = "LZ4"
+		case drct.Name == "compress" && len(drct.Args) > 0 &&
+			drct.Args[0] == "lzo":
+			feature = "LZO"
+		}
+
+		if feature != "" && len(info.Features) > 0 &&
+			!info.HasFeature(feature) {
+
+			err = errors.New("profile: OpenVPN " + info.Version +
+				" built without " + feature + " for " + drct.Name +
+				" on line " + strconv.Itoa(drct.Line))
+			return
+		}
+	}
+
+	return
+}
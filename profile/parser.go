@@ -0,0 +1,224 @@
+package profile
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Directive line of OpenVPN configuration, line numbers start at 1
+type Directive struct {
+	Line int      `json:"line"`
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+}
+
+// Inline file block such as <ca>
+type Block struct {
+	Line int    `json:"line"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type Config struct {
+	Directives []*Directive      `json:"directives"`
+	Blocks     []*Block          `json:"blocks"`
+	Env        map[string]string `json:"env"`
+	Ignored    map[string]bool   `json:"-"`
+}
+
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return "profile: Line " + strconv.Itoa(e.Line) + " " + e.Message
+}
+
+// Split directive line into fields, handles quotes, backslash escapes and
+// whitespace same as OpenVPN
+func splitLine(line string) (fields []string, err error) {
+	fields = []string{}
+	field := ""
+	inField := false
+	quote := rune(0)
+	escape := false
+
+	for _, c := range line {
+		switch {
+		case escape:
+			field += string(c)
+			escape = false
+		case c == '\\' && quote != '\'':
+			escape = true
+			inField = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				field += string(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == '\r':
+			if inField {
+				fields = append(fields, field)
+				field = ""
+				inField = false
+			}
+		case (c == '#' || c == ';') && !inField:
+			return
+		default:
+			field += string(c)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		err = errors.New("unterminated quote")
+		return
+	}
+
+	if inField {
+		fields = append(fields, field)
+	}
+
+	return
+}
+
+// Parse OpenVPN configuration
+func Parse(data string) (conf *Config, err error) {
+	conf = &Config{
+		Directives: []*Directive{},
+		Blocks:     []*Block{},
+		Env:        map[string]string{},
+		Ignored:    map[string]bool{},
+	}
+
+	var block *Block
+
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if block != nil {
+			if trimmed == "</"+block.Name+">" {
+				conf.Blocks = append(conf.Blocks, block)
+				block = nil
+			} else {
+				block.Data += trimmed + "\n"
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "<") &&
+			strings.HasSuffix(trimmed, ">") {
+
+			if strings.HasPrefix(trimmed, "</") {
+				err = &ParseError{
+					Line:    i + 1,
+					Message: "unexpected " + trimmed,
+				}
+				return
+			}
+
+			block = &Block{
+				Line: i + 1,
+				Name: trimmed[1 : len(trimmed)-1],
+			}
+			continue
+		}
+
+		fields, e := splitLine(trimmed)
+		if e != nil {
+			err = &ParseError{
+				Line:    i + 1,
+				Message: e.Error(),
+			}
+			return
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		drct := &Directive{
+			Line: i + 1,
+			Name: strings.TrimPrefix(fields[0], "--"),
+			Args: fields[1:],
+		}
+
+		if strings.IndexFunc(drct.Name, unicode.IsControl) != -1 {
+			err = &ParseError{
+				Line:    i + 1,
+				Message: "invalid directive " + strconv.Quote(drct.Name),
+			}
+			return
+		}
+		conf.Directives = append(conf.Directives, drct)
+
+		switch drct.Name {
+		case "setenv", "setenv-safe":
+			if len(drct.Args) > 1 && drct.Args[0] == "opt" {
+				// option ignored when unknown to openvpn
+				conf.Ignored[drct.Args[1]] = true
+			} else if len(drct.Args) > 0 {
+				val := ""
+				if len(drct.Args) > 1 {
+					val = drct.Args[1]
+				}
+				conf.Env[drct.Args[0]] = val
+			}
+		case "ignore-unknown-option":
+			for _, name := range drct.Args {
+				conf.Ignored[name] = true
+			}
+		}
+	}
+
+	if block != nil {
+		err = &ParseError{
+			Line:    block.Line,
+			Message: "unterminated <" + block.Name + ">",
+		}
+		return
+	}
+
+	return
+}
+
+// Get directives with name
+func (c *Config) Get(name string) (drcts []*Directive) {
+	for _, drct := range c.Directives {
+		if drct.Name == name {
+			drcts = append(drcts, drct)
+		}
+	}
+	return
+}
+
+// Get last directive with name, nil if missing
+func (c *Config) Last(name string) (drct *Directive) {
+	for _, d := range c.Directives {
+		if d.Name == name {
+			drct = d
+		}
+	}
+	return
+}
+
+func (c *Config) Has(name string) bool {
+	return c.Last(name) != nil
+}
+
+// Get inline block with name, nil if missing
+func (c *Config) Block(name string) *Block {
+	for _, block := range c.Blocks {
+		if block.Name == name {
+			return block
+		}
+	}
+	return nil
+}
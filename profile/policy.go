@@ -0,0 +1,222 @@
+package profile
+
+import (
+	"github.com/AlexeySpiridonov/goapp-config"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// directives running commands or touching files as root
+	defaultDenied = []string{
+		"askpass",
+		"auth-user-pass-verify",
+		"cd",
+		"chroot",
+		"client-connect",
+		"client-disconnect",
+		"config",
+		"daemon",
+		"dns-updown",
+		"down",
+		"engine",
+		"ipchange",
+		"iproute",
+		"learn-address",
+		"log",
+		"log-append",
+		"management",
+		"pkcs11-providers",
+		"plugin",
+		"providers",
+		"replay-persist",
+		"route-pre-down",
+		"route-up",
+		"script-security",
+		"status",
+		"tls-export-cert",
+		"tls-verify",
+		"tmp-dir",
+		"up",
+		"writepid",
+	}
+	// directives reading file given as argument, value is argument index
+	fileArgDirectives = map[string]int{
+		"auth-user-pass": 0,
+		"http-proxy":     2,
+	}
+	// arguments of file directives not naming file
+	fileArgKeywords = map[string]bool{
+		"[inline]": true,
+		"auto":     true,
+		"auto-nct": true,
+		"stdin":    true,
+	}
+)
+
+type PolicyError struct {
+	Line      int
+	Directive string
+	File      string
+}
+
+func (e *PolicyError) Error() string {
+	if e.File != "" {
+		return "profile: File " + e.File + " of " + e.Directive +
+			" on line " + strconv.Itoa(e.Line) + " not allowed"
+	}
+	return "profile: Directive " + e.Directive + " on line " +
+		strconv.Itoa(e.Line) + " not allowed"
+}
+
+// File read by directive, credentials are sent over management interface
+// so file forms are never allowed
+func fileArg(drct *Directive) string {
+	i, ok := fileArgDirectives[drct.Name]
+	if !ok || len(drct.Args) <= i || fileArgKeywords[drct.Args[i]] {
+		return ""
+	}
+	return drct.Args[i]
+}
+
+func parseList(val string) (names map[string]bool) {
+	names = map[string]bool{}
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names[name] = true
+		}
+	}
+	return
+}
+
+type directivePolicy struct {
+	allowed map[string]bool
+	denied  map[string]bool
+}
+
+func (d *directivePolicy) allows(name string) bool {
+	if d.allowed != nil {
+		return d.allowed[name]
+	}
+	return !d.denied[name]
+}
+
+// Get directive policy from config, allowedDirectives switches to
+// allowlist and deniedDirectives replaces default denylist
+func getPolicy() (policy *directivePolicy) {
+	policy = &directivePolicy{}
+
+	if val := config.Local.Get("allowedDirectives"); val != "" {
+		policy.allowed = parseList(val)
+		return
+	}
+
+	if val := config.Local.Get("deniedDirectives"); val != "" {
+		policy.denied = parseList(val)
+		return
+	}
+
+	policy.denied = map[string]bool{}
+	for _, name := range defaultDenied {
+		policy.denied[name] = true
+	}
+	return
+}
+
+// Check directive against policy, nil when allowed
+func (d *directivePolicy) check(drct *Directive) (err *PolicyError) {
+	if !d.allows(drct.Name) ||
+		strings.IndexFunc(drct.Name, unicode.IsControl) != -1 {
+
+		err = &PolicyError{
+			Line:      drct.Line,
+			Directive: drct.Name,
+		}
+		return
+	}
+
+	if pth := fileArg(drct); pth != "" {
+		err = &PolicyError{
+			Line:      drct.Line,
+			Directive: drct.Name,
+			File:      pth,
+		}
+		return
+	}
+
+	return
+}
+
+// Directives run by openvpn including options wrapped in setenv opt and
+// bodies of <connection> blocks, lines are lines of profile data
+func policyDirectives(conf *Config) (drcts []*Directive, err error) {
+	for _, drct := range conf.Directives {
+		drcts = append(drcts, drct)
+
+		if (drct.Name == "setenv" || drct.Name == "setenv-safe") &&
+			len(drct.Args) > 1 && drct.Args[0] == "opt" {
+
+			drcts = append(drcts, &Directive{
+				Line: drct.Line,
+				Name: strings.TrimPrefix(drct.Args[1], "--"),
+				Args: drct.Args[2:],
+			})
+		}
+	}
+
+	for _, block := range conf.Blocks {
+		if block.Name != "connection" {
+			continue
+		}
+
+		blockConf, e := Parse(block.Data)
+		if e != nil {
+			if pe, ok := e.(*ParseError); ok {
+				pe.Line += block.Line
+			}
+			err = e
+			return
+		}
+
+		blockDrcts, e := policyDirectives(blockConf)
+		if e != nil {
+			err = e
+			return
+		}
+
+		// block data starts on line after <connection>
+		for _, drct := range blockDrcts {
+			drct.Line += block.Line
+			drcts = append(drcts, drct)
+		}
+	}
+
+	return
+}
+
+// Check profile data against directive policy, error names first
+// offending line
+func CheckPolicy(data string) (err error) {
+	conf, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	drcts, err := policyDirectives(conf)
+	if err != nil {
+		return
+	}
+
+	policy := getPolicy()
+
+	for _, drct := range drcts {
+		if e := policy.check(drct); e != nil {
+			err = e
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,139 @@
+package profile
+
+import (
+	"testing"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		line      int
+		directive string
+		file      string
+	}{
+		{
+			name: "allowed",
+			data: "client\ndev tun\nremote vpn.example.com 1194 udp\n",
+		},
+		{
+			name:      "denied directive",
+			data:      "client\nup /tmp/x\n",
+			line:      2,
+			directive: "up",
+		},
+		{
+			name:      "denied after dashes",
+			data:      "--plugin /tmp/x.so\n",
+			line:      1,
+			directive: "plugin",
+		},
+		{
+			name:      "form feed separator",
+			data:      "client\nplugin\f/tmp/x.so\n",
+			line:      2,
+			directive: "plugin",
+		},
+		{
+			name:      "vertical tab separator",
+			data:      "client\nroute-up\v/tmp/x\n",
+			line:      2,
+			directive: "route-up",
+		},
+		{
+			name:      "setenv opt",
+			data:      "client\nsetenv opt up /tmp/x\n",
+			line:      2,
+			directive: "up",
+		},
+		{
+			name:      "setenv-safe opt",
+			data:      "setenv-safe opt tls-verify /tmp/x\n",
+			line:      1,
+			directive: "tls-verify",
+		},
+		{
+			name: "setenv variable",
+			data: "setenv UV_NAME up\n",
+		},
+		{
+			name:      "setenv opt file argument",
+			data:      "setenv opt auth-user-pass /root/creds\n",
+			line:      1,
+			directive: "auth-user-pass",
+			file:      "/root/creds",
+		},
+		{
+			name:      "auth-user-pass file",
+			data:      "client\nauth-user-pass creds.txt\n",
+			line:      2,
+			directive: "auth-user-pass",
+			file:      "creds.txt",
+		},
+		{
+			name: "auth-user-pass prompt",
+			data: "client\nauth-user-pass\n",
+		},
+		{
+			name: "http-proxy auto",
+			data: "http-proxy proxy.example.com 8080 auto\n",
+		},
+		{
+			name: "connection block",
+			data: "client\n<connection>\nremote vpn.example.com 1194\n" +
+				"</connection>\n",
+		},
+		{
+			name: "connection block file",
+			data: "client\n<connection>\nremote vpn.example.com 1194\n" +
+				"http-proxy proxy.example.com 8080 /root/file\n" +
+				"</connection>\n",
+			line:      4,
+			directive: "http-proxy",
+			file:      "/root/file",
+		},
+		{
+			name: "connection block setenv opt",
+			data: "client\n\n<connection>\nremote vpn.example.com\n" +
+				"setenv opt plugin /tmp/x.so\n</connection>\n",
+			line:      5,
+			directive: "plugin",
+		},
+	}
+
+	for _, test := range tests {
+		err := CheckPolicy(test.data)
+
+		if test.directive == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", test.name, err)
+			}
+			continue
+		}
+
+		e, ok := err.(*PolicyError)
+		if !ok {
+			t.Errorf("%s: expected policy error, got %v", test.name, err)
+			continue
+		}
+
+		if e.Line != test.line || e.Directive != test.directive ||
+			e.File != test.file {
+
+			t.Errorf("%s: got line %d directive %q file %q", test.name,
+				e.Line, e.Directive, e.File)
+		}
+	}
+}
+
+func TestCheckPolicyControl(t *testing.T) {
+	err := CheckPolicy("client\nplu\x01gin /tmp/x.so\n")
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected parse error, got %v", err)
+	}
+
+	err = CheckPolicy("setenv opt plu\x01gin /tmp/x.so\n")
+	if _, ok := err.(*PolicyError); !ok {
+		t.Errorf("expected policy error, got %v", err)
+	}
+}
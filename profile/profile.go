@@ -18,6 +18,7 @@ import (
 	"github.com/op/go-logging"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -51,17 +52,33 @@ type OutputData struct {
 
 type Profile struct {
 	Id              string           `json:"id"`
+	Name            string           `json:"name"`
 	Data            string           `json:"-"`
 	Username        string           `json:"-"`
 	Password        string           `json:"-"`
 	ServerPublicKey string           `json:"-"`
+	KeyPassphrase   string           `json:"-"`
 	Reconnect       bool             `json:"reconnect"`
-	Status          string           `json:"status"`
+	ReconnectPolicy *ReconnectPolicy `json:"reconnect_policy"`
+	Timeouts        *ConnectTimeouts `json:"timeouts"`
+	KillSwitch      bool             `json:"kill_switch"`
+	IncludeRoutes   []string         `json:"include_routes"`
+	ExcludeRoutes   []string         `json:"exclude_routes"`
+	RouteConflict   string           `json:"route_conflict"`
+	Routes          []*Route         `json:"routes"`
+	Status          State            `json:"status"`
+	FailReason      string           `json:"fail_reason,omitempty"`
 	Timestamp       int64            `json:"timestamp"`
 	ServerAddr      string           `json:"server_addr"`
 	ClientAddr      string           `json:"client_addr"`
+	BytesIn         uint64           `json:"bytes_in"`
+	BytesOut        uint64           `json:"bytes_out"`
+	RateIn          uint64           `json:"rate_in"`
+	RateOut         uint64           `json:"rate_out"`
+	Uptime          int64            `json:"uptime"`
 	state           bool             `json:"-"`
 	stateLock       sync.Mutex       `json:"-"`
+	statusLock      sync.Mutex       `json:"-"`
 	stop            bool             `json:"-"`
 	waiters         []chan bool      `json:"-"`
 	remPaths        []string         `json:"-"`
@@ -69,6 +86,30 @@ type Profile struct {
 	intf            *utils.Interface `json:"-"`
 	lastAuthErr     time.Time        `json:"-"`
 	token           *token.Token     `json:"-"`
+	mgmt            *management      `json:"-"`
+	lastBytecount   time.Time        `json:"-"`
+	attempts        int              `json:"-"`
+	remotes         []*RemoteProbe   `json:"-"`
+	stage           string           `json:"-"`
+	stageStart      time.Time        `json:"-"`
+	challenge       *challenge       `json:"-"`
+	challengeLock   sync.Mutex       `json:"-"`
+	credsPrompt     string           `json:"-"`
+	credsTimer      *time.Timer      `json:"-"`
+	killSwitch      *killSwitch      `json:"-"`
+	includeNets     []*net.IPNet     `json:"-"`
+	excludeNets     []*net.IPNet     `json:"-"`
+	routeEnv        *routeEnv        `json:"-"`
+	netTable        *NetTable        `json:"-"`
+}
+
+type StatsData struct {
+	Id       string `json:"id"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	RateIn   uint64 `json:"rate_in"`
+	RateOut  uint64 `json:"rate_out"`
+	Uptime   int64  `json:"uptime"`
 }
 
 type AuthData struct {
@@ -86,7 +127,12 @@ func (p *Profile) write() (pth string, err error) {
 
 	pth = filepath.Join(rootDir, p.Id)
 
-	err = ioutil.WriteFile(pth, []byte(p.Data), os.FileMode(0600))
+	data := orderRemotes(p.Data, p.remotes)
+	if p.killSwitch != nil {
+		data = p.killSwitch.pinRemotes(data)
+	}
+
+	err = ioutil.WriteFile(pth, []byte(data), os.FileMode(0600))
 	if err != nil {
 		err = errors.New("profile: Failed to write profile " + err.Error())
 	}
@@ -197,78 +243,72 @@ func (p *Profile) writeBlock() (pth string, err error) {
 	return
 }
 
-func (p *Profile) writeAuth() (pth string, err error) {
-	rootDir, err := utils.GetTempDir()
-	if err != nil {
+// Get password for auth, wrapped with server public key when set
+func (p *Profile) authPassword() (password string, err error) {
+	password = p.Password
+
+	if p.ServerPublicKey == "" {
 		return
 	}
 
-	password := p.Password
-
-	if p.ServerPublicKey != "" {
-		block, _ := pem.Decode([]byte(p.ServerPublicKey))
-
-		pub, e := x509.ParsePKCS1PublicKey(block.Bytes)
-		if e != nil {
-			e = errors.New("profile: Failed to parse public key " + e.Error())
-			return pth, e
-		}
-
-		nonce, e := utils.RandStr(32)
-		if e != nil {
-			return pth, e
-		}
-
-		tokn := token.Get(p.Id, p.ServerPublicKey)
-		p.token = tokn
+	block, _ := pem.Decode([]byte(p.ServerPublicKey))
+	if block == nil {
+		err = errors.New("profile: Failed to decode public key")
+		return
+	}
 
-		authToken := ""
-		if tokn != nil {
-			e = tokn.Update()
-			if e != nil {
-				return pth, e
-			}
+	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		err = errors.New("profile: Failed to parse public key " + err.Error())
+		return
+	}
 
-			authToken = tokn.Token
-		}
+	nonce, err := utils.RandStr(32)
+	if err != nil {
+		return
+	}
 
-		authData := &AuthData{
-			Token:     authToken,
-			Password:  password,
-			Nonce:     nonce,
-			Timestamp: time.Now().Unix(),
-		}
+	tokn := token.Get(p.Id, p.ServerPublicKey)
+	p.token = tokn
 
-		authDataJson, e := json.Marshal(authData)
-		if e != nil {
-			e = errors.New("profile: Failed to encode auth data " + e.Error())
-			return pth, e
+	authToken := ""
+	if tokn != nil {
+		err = tokn.Update()
+		if err != nil {
+			return
 		}
 
-		ciphertext, e := rsa.EncryptOAEP(
-			sha512.New(),
-			rand.Reader,
-			pub,
-			authDataJson,
-			[]byte{},
-		)
-		if e != nil {
-			e = errors.New("profile: Failed to encrypt auth data " + e.Error())
-			return pth, e
-		}
+		authToken = tokn.Token
+	}
 
-		ciphertext64 := base64.StdEncoding.EncodeToString(ciphertext)
-		password = "<%=RSA_ENCRYPTED=%>" + ciphertext64
+	authData := &AuthData{
+		Token:     authToken,
+		Password:  password,
+		Nonce:     nonce,
+		Timestamp: time.Now().Unix(),
 	}
 
-	pth = filepath.Join(rootDir, p.Id+".auth")
+	authDataJson, err := json.Marshal(authData)
+	if err != nil {
+		err = errors.New("profile: Failed to encode auth data " + err.Error())
+		return
+	}
 
-	err = ioutil.WriteFile(pth, []byte(p.Username+"\n"+password+"\n"),
-		os.FileMode(0600))
+	ciphertext, err := rsa.EncryptOAEP(
+		sha512.New(),
+		rand.Reader,
+		pub,
+		authDataJson,
+		[]byte{},
+	)
 	if err != nil {
-		err = errors.New("profile: Failed to write profile auth " + err.Error())
+		err = errors.New("profile: Failed to encrypt auth data " + err.Error())
+		return
 	}
 
+	ciphertext64 := base64.StdEncoding.EncodeToString(ciphertext)
+	password = "<%=RSA_ENCRYPTED=%>" + ciphertext64
+
 	return
 }
 
@@ -310,15 +350,8 @@ func (p *Profile) pushOutput(output string) {
 func (p *Profile) parseLine(line string) {
 	p.pushOutput(string(line))
 
-	if strings.Contains(line, "Initialization Sequence Completed") {
-		p.Status = "connected"
-		p.Timestamp = time.Now().Unix() - 5
-		p.update()
-
-		tokn := p.token
-		if tokn != nil {
-			tokn.Valid = true
-		}
+	if strings.Contains(
+		line, "Can't assign requested address (code=49)") {
 
 		go func() {
 			defer func() {
@@ -328,17 +361,117 @@ func (p *Profile) parseLine(line string) {
 				}
 			}()
 
-			utils.ClearDNSCache()
+			time.Sleep(3 * time.Second)
+
+			if !p.stop {
+				RestartProfiles(true)
+			}
 		}()
-	} else if strings.Contains(line, "Inactivity timeout (--inactive)") {
-		evt := events.Event{
-			Type: "inactive",
-			Data: p,
+	}
+}
+
+// Handle state from management interface
+func (p *Profile) parseState(state, desc, localAddr, remoteAddr string) {
+	p.setStage(state)
+
+	switch state {
+	case "CONNECTED":
+		if desc != "SUCCESS" {
+			log.Warning("profile: Connected with errors", p.Id, desc)
 		}
-		evt.Init()
+		if localAddr != "" {
+			p.ClientAddr = localAddr
+		}
+		if remoteAddr != "" {
+			p.ServerAddr = remoteAddr
+		}
+		p.connected()
+	case "ASSIGN_IP":
+		if localAddr != "" {
+			p.ClientAddr = localAddr
+			p.update()
+		}
+	case "AUTH_PENDING":
+		p.setState(AuthPending, "auth_pending")
+	case "RECONNECTING":
+		switch desc {
+		case "ping-restart":
+			p.pingRestart()
+		case "auth-failure":
+			p.authFailed()
+		default:
+			p.setState(Reconnecting, desc)
+		}
+	case "EXITING":
+		switch desc {
+		case "inactive":
+			p.inactive()
+		case "auth-failure":
+			p.authFailed()
+		}
+	}
+}
+
+// Update traffic counters from management bytecount and push stats, rate
+// is bytes per second since previous bytecount
+func (p *Profile) parseBytecount(bytesIn, bytesOut uint64) {
+	now := time.Now()
+
+	if !p.lastBytecount.IsZero() {
+		elapsed := now.Sub(p.lastBytecount).Seconds()
+		if elapsed > 0 {
+			p.RateIn = rate(p.BytesIn, bytesIn, elapsed)
+			p.RateOut = rate(p.BytesOut, bytesOut, elapsed)
+		}
+	}
+
+	p.lastBytecount = now
+	p.BytesIn = bytesIn
+	p.BytesOut = bytesOut
+
+	if p.Status == Connected && p.Timestamp != 0 {
+		p.Uptime = now.Unix() - p.Timestamp
+	} else {
+		p.Uptime = 0
+	}
+
+	evt := events.Event{
+		Type: "stats",
+		Data: &StatsData{
+			Id:       p.Id,
+			BytesIn:  p.BytesIn,
+			BytesOut: p.BytesOut,
+			RateIn:   p.RateIn,
+			RateOut:  p.RateOut,
+			Uptime:   p.Uptime,
+		},
+	}
+	evt.Init()
+}
+
+// Counters restart from zero when openvpn reconnects
+func rate(prev, cur uint64, elapsed float64) uint64 {
+	if cur < prev {
+		prev = 0
+	}
+	return uint64(float64(cur-prev) / elapsed)
+}
+
+func (p *Profile) connected() {
+	p.Timestamp = time.Now().Unix() - 5
+	p.attempts = 0
+	p.loadRoutes()
+	if p.routeEnv != nil {
+		p.setKillSwitchIntf(p.routeEnv.dev)
+	}
+
+	err := p.applyNetTable()
+	if err != nil {
+		log.Error("profile: Failed to configure tunnel", p.Id, err)
 
 		p.stop = true
-	} else if strings.Contains(line, "Inactivity timeout") {
+		p.setState(Failed, "routes_failed")
+
 		go func() {
 			defer func() {
 				err := recover()
@@ -347,100 +480,89 @@ func (p *Profile) parseLine(line string) {
 				}
 			}()
 
-			prfl := p.Copy()
-
-			stop := p.stop
-
-			err := p.Stop()
+			err := p.terminate(3 * time.Second)
 			if err != nil {
-				log.Error("profile: Stop error", err)
-				return
+				log.Error("profile: Failed to stop profile", p.Id, err)
 			}
+		}()
+		return
+	}
 
-			p.Wait()
+	p.setState(Connected, "connected")
 
-			if !stop && prfl.Reconnect {
-				err = prfl.Start(false)
-				if err != nil {
-					log.Error("profile: Restart error", err)
-					return
-				}
+	tokn := p.token
+	if tokn != nil {
+		tokn.Valid = true
+	}
+
+	go func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				log.Panic("profile: Panic", err)
 			}
 		}()
-	} else if strings.Contains(
-		line, "Can't assign requested address (code=49)") {
 
-		go func() {
-			defer func() {
-				err := recover()
-				if err != nil {
-					log.Panic("profile: Panic", err)
-				}
-			}()
+		utils.ClearDNSCache()
+	}()
+}
 
-			time.Sleep(3 * time.Second)
+func (p *Profile) inactive() {
+	evt := events.Event{
+		Type: "inactive",
+		Data: p,
+	}
+	evt.Init()
 
-			if !p.stop {
-				RestartProfiles(true)
+	p.stop = true
+}
+
+func (p *Profile) pingRestart() {
+	go func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				log.Panic("profile: Panic", err)
 			}
 		}()
-	} else if strings.Contains(line, "AUTH_FAILED") || strings.Contains(
-		line, "auth-failure") {
 
-		p.stop = true
+		stop := p.stop
 
-		tokn := p.token
-		if tokn != nil {
-			tokn.Init()
+		err := p.Stop()
+		if err != nil {
+			log.Error("profile: Stop error", err)
+			return
 		}
 
-		if time.Since(p.lastAuthErr) > 10*time.Second {
-			p.lastAuthErr = time.Now()
-
-			evt := events.Event{
-				Type: "auth_error",
-				Data: p,
-			}
-			evt.Init()
+		if !stop {
+			p.scheduleReconnect(TriggerPing)
 		}
-	} else if strings.Contains(line, "link remote:") {
-		sIndex := strings.LastIndex(line, "]") + 1
-		eIndex := strings.LastIndex(line, ":")
+	}()
+}
 
-		p.ServerAddr = line[sIndex:eIndex]
-		p.update()
-	} else if strings.Contains(line, "network/local/netmask") {
-		eIndex := strings.LastIndex(line, "/")
-		line = line[:eIndex]
-		sIndex := strings.LastIndex(line, "/") + 1
+func (p *Profile) authFailed() {
+	// dynamic challenge is reported as auth failure
+	if p.hasChallenge() {
+		return
+	}
 
-		p.ClientAddr = line[sIndex:]
-		p.update()
-	} else if strings.Contains(line, "ifconfig") && strings.Contains(
-		line, "netmask") {
+	p.stop = true
+	p.setState(Failed, "auth_failed")
+	ClearSessionCredentials(p.Id)
 
-		sIndex := strings.Index(line, "ifconfig") + 9
-		eIndex := strings.Index(line, "netmask")
-		line = line[sIndex:eIndex]
+	tokn := p.token
+	if tokn != nil {
+		tokn.Init()
+	}
 
-		split := strings.Split(line, " ")
-		if len(split) > 2 {
-			p.ClientAddr = split[1]
-			p.update()
-		}
-	} else if strings.Contains(line, "ip addr add dev") {
-		sIndex := strings.Index(line, "ip addr add dev") + 16
-		eIndex := strings.Index(line, "broadcast")
-		line = line[sIndex:eIndex]
-		split := strings.Split(line, " ")
-
-		if len(split) > 1 {
-			split := strings.Split(split[1], "/")
-			if len(split) > 1 {
-				p.ClientAddr = split[0]
-				p.update()
-			}
+	if time.Since(p.lastAuthErr) > 10*time.Second {
+		p.lastAuthErr = time.Now()
+
+		evt := events.Event{
+			Type: "auth_error",
+			Data: p,
 		}
+		evt.Init()
 	}
 }
 
@@ -449,6 +571,10 @@ func (p *Profile) clearStatus(start time.Time) {
 		utils.ReleaseTap(p.intf)
 	}
 
+	if p.mgmt != nil {
+		p.mgmt.close()
+	}
+
 	go func() {
 		defer func() {
 			err := recover()
@@ -462,10 +588,21 @@ func (p *Profile) clearStatus(start time.Time) {
 			time.Sleep(1 * time.Second)
 		}
 
-		p.Status = "disconnected"
+		// failed state is kept with reason
+		if p.Status != Failed {
+			p.setState(Disconnected, "exit")
+		}
 		p.Timestamp = 0
 		p.ClientAddr = ""
 		p.ServerAddr = ""
+		p.BytesIn = 0
+		p.BytesOut = 0
+		p.RateIn = 0
+		p.RateOut = 0
+		p.Uptime = 0
+		p.Routes = nil
+		p.routeEnv = nil
+		p.removeNetTable()
 		p.update()
 
 		for _, path := range p.remPaths {
@@ -497,11 +634,20 @@ func (p *Profile) clearStatus(start time.Time) {
 func (p *Profile) Copy() (prfl *Profile) {
 	prfl = &Profile{
 		Id:              p.Id,
+		Name:            p.Name,
 		Data:            p.Data,
 		Username:        p.Username,
 		Password:        p.Password,
 		ServerPublicKey: p.ServerPublicKey,
+		KeyPassphrase:   p.KeyPassphrase,
 		Reconnect:       p.Reconnect,
+		ReconnectPolicy: p.ReconnectPolicy,
+		Timeouts:        p.Timeouts,
+		KillSwitch:      p.KillSwitch,
+		IncludeRoutes:   p.IncludeRoutes,
+		ExcludeRoutes:   p.ExcludeRoutes,
+		RouteConflict:   p.RouteConflict,
+		attempts:        p.attempts,
 	}
 	prfl.Init()
 
@@ -510,6 +656,7 @@ func (p *Profile) Copy() (prfl *Profile) {
 
 func (p *Profile) Init() {
 	p.Id = FilterStr(p.Id)
+	p.Status = Disconnected
 	p.stateLock = sync.Mutex{}
 	p.waiters = []chan bool{}
 }
@@ -518,7 +665,28 @@ func (p *Profile) Start(timeout bool) (err error) {
 	start := time.Now()
 	p.remPaths = []string{}
 
-	p.Status = "connecting"
+	// stored profiles may predate directive policy
+	err = CheckPolicy(p.Data)
+	if err != nil {
+		return
+	}
+
+	err = CheckCertificates(p.Id, p.Name, p.Data)
+	if err != nil {
+		return
+	}
+
+	ovpn := GetOpenvpn()
+	err = checkOpenvpn(ovpn, p.Data)
+	if err != nil {
+		return
+	}
+
+	if !p.setState(Connecting, "start") {
+		err = errors.New("profile: Profile is not startable " +
+			string(p.Status))
+		return
+	}
 	p.stateLock.Lock()
 	p.state = true
 	p.stateLock.Unlock()
@@ -541,29 +709,37 @@ func (p *Profile) Start(timeout bool) (err error) {
 	Profiles.m[p.Id] = p
 	Profiles.Unlock()
 
-	confPath, err := p.write()
-	if err != nil {
-		p.clearStatus(start)
-		return
-	}
-	p.remPaths = append(p.remPaths, confPath)
+	p.probeRemotes()
 
-	var authPath string
-	if (p.Username != "" && p.Password != "") || p.ServerPublicKey != "" {
-		authPath, err = p.writeAuth()
+	if p.KillSwitch {
+		p.killSwitch, err = p.enableKillSwitch()
 		if err != nil {
 			p.clearStatus(start)
 			return
 		}
-		p.remPaths = append(p.remPaths, authPath)
 	}
 
+	confPath, err := p.write()
+	if err != nil {
+		p.clearStatus(start)
+		return
+	}
+	p.remPaths = append(p.remPaths, confPath)
+
 	p.update()
 
+	mgmt, mgmtArgs, err := newManagement(p)
+	if err != nil {
+		p.clearStatus(start)
+		return
+	}
+	p.mgmt = mgmt
+
 	args := []string{
 		"--config", confPath,
 		"--verb", "2",
 	}
+	args = append(args, mgmtArgs...)
 
 	if runtime.GOOS == "windows" {
 		p.intf, err = utils.AcquireTap()
@@ -603,13 +779,20 @@ func (p *Profile) Start(timeout bool) (err error) {
 		}
 		p.remPaths = append(p.remPaths, downPath)
 
+		routeUpPath, e := p.writeRouteUp()
+		if e != nil {
+			p.clearStatus(start)
+			return e
+		}
+		p.remPaths = append(p.remPaths, routeUpPath, routeUpPath+".env")
+
 		args = append(args, "--script-security", "2",
 			"--up", upPath,
 			"--down", downPath,
 			"--route-pre-down", blockPath,
 			"--tls-verify", blockPath,
 			"--ipchange", blockPath,
-			"--route-up", blockPath,
+			"--route-up", routeUpPath,
 		)
 		break
 	case "linux":
@@ -627,24 +810,43 @@ func (p *Profile) Start(timeout bool) (err error) {
 		}
 		p.remPaths = append(p.remPaths, downPath)
 
+		routeUpPath, e := p.writeRouteUp()
+		if e != nil {
+			p.clearStatus(start)
+			return e
+		}
+		p.remPaths = append(p.remPaths, routeUpPath, routeUpPath+".env")
+
 		args = append(args, "--script-security", "2",
 			"--up", upPath,
 			"--down", downPath,
 			"--route-pre-down", blockPath,
 			"--tls-verify", blockPath,
 			"--ipchange", blockPath,
-			"--route-up", blockPath,
+			"--route-up", routeUpPath,
 		)
+
+		// addresses and routes are installed with netlink after connect
+		if netlinkSupported {
+			args = append(args, "--route-noexec", "--ifconfig-noexec")
+		}
 		break
 	default:
 		log.Panic("profile: Not implemented")
 	}
 
-	if authPath != "" {
-		args = append(args, "--auth-user-pass", authPath)
+	// credentials are sent on management password request, failed auth
+	// is asked again for dynamic challenge
+	if (p.Username != "" && p.Password != "") || p.ServerPublicKey != "" ||
+		NeedsCredentials(p.Data) {
+
+		args = append(args, "--auth-user-pass")
 	}
+	args = append(args, "--auth-retry", "interact")
+	args = append(args, p.changeArgs()...)
+	args = append(args, p.splitArgs()...)
 
-	cmd := command.Command(getOpenvpnPath(), args...)
+	cmd := command.Command(ovpn.Path, args...)
 	cmd.Dir = getOpenvpnDir()
 	p.cmd = cmd
 
@@ -758,7 +960,17 @@ func (p *Profile) Start(timeout bool) (err error) {
 		return
 	}
 
-	running := true
+	go func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				log.Panic("profile: Panic", err)
+			}
+		}()
+
+		mgmt.run()
+	}()
+
 	go func() {
 		defer func() {
 			err := recover()
@@ -769,7 +981,6 @@ func (p *Profile) Start(timeout bool) (err error) {
 
 		cmd.Wait()
 		outputWait.Wait()
-		running = false
 
 		if runtime.GOOS == "darwin" {
 			err = utils.RestoreScutilDns()
@@ -780,58 +991,20 @@ func (p *Profile) Start(timeout bool) (err error) {
 
 		if !p.stop {
 			log.Error("profile: Unexpected profile exit", p.Id)
+			p.setState(Failed, "unexpected_exit")
+			p.scheduleReconnect(TriggerExit)
 		}
 		p.clearStatus(start)
 	}()
 
-	if timeout {
-		go func() {
-			defer func() {
-				err := recover()
-				if err != nil {
-					log.Panic("profile: Panic", err)
-				}
-			}()
-
-			time.Sleep(connTimeout)
-			if p.Status != "connected" && running {
-				if runtime.GOOS == "windows" {
-					cmd.Process.Kill()
-				} else {
-					err = p.cmd.Process.Signal(os.Interrupt)
-					if err != nil {
-						err = errors.New("profile: Failed to interrupt openvpn " + err.Error())
-						return
-					}
-
-					done := false
-
-					go func() {
-						defer func() {
-							err := recover()
-							if err != nil {
-								log.Panic("profile: Panic", err)
-							}
-						}()
-
-						time.Sleep(3 * time.Second)
-						if done {
-							return
-						}
-						p.cmd.Process.Kill()
-					}()
-
-					p.cmd.Process.Wait()
-					done = true
-				}
-
-				evt := events.Event{
-					Type: "timeout_error",
-					Data: p,
-				}
-				evt.Init()
-			}
-		}()
+	timeouts := p.Timeouts
+	if timeouts == nil && timeout {
+		timeouts = &ConnectTimeouts{
+			Connect: int(connTimeout / time.Second),
+		}
+	}
+	if timeouts != nil {
+		go p.watchTimeouts(timeouts, start)
 	}
 
 	return
@@ -845,37 +1018,48 @@ func (p *Profile) Stop() (err error) {
 	log.Info("profile: Disconnecting", p.Id)
 
 	p.stop = true
-	p.Status = "disconnecting"
-	p.update()
+	p.setState(Disconnecting, "stop")
 
-	if runtime.GOOS == "windows" {
-		err = p.cmd.Process.Kill()
-		if err != nil {
-			err = errors.New("profile: Failed to stop openvpn " + err.Error())
+	err = p.terminate(5 * time.Second)
+
+	return
+}
+
+// Ask openvpn to exit through management interface, falls back to signals
+// when management is not connected. Kills process after wait.
+func (p *Profile) terminate(wait time.Duration) (err error) {
+	mgmt := p.mgmt
+	if mgmt == nil || mgmt.send("signal SIGTERM") != nil {
+		if runtime.GOOS == "windows" {
+			err = p.cmd.Process.Kill()
+			if err != nil {
+				err = errors.New("profile: Failed to stop openvpn " + err.Error())
+			}
 			return
 		}
-	} else {
+
 		p.cmd.Process.Signal(os.Interrupt)
-		done := false
+	}
 
-		go func() {
-			defer func() {
-				err := recover()
-				if err != nil {
-					log.Panic("profile: Panic", err)
-				}
-			}()
+	done := false
 
-			time.Sleep(5 * time.Second)
-			if done {
-				return
+	go func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				log.Panic("profile: Panic", err)
 			}
-			p.cmd.Process.Kill()
 		}()
 
-		p.cmd.Process.Wait()
-		done = true
-	}
+		time.Sleep(wait)
+		if done {
+			return
+		}
+		p.cmd.Process.Kill()
+	}()
+
+	p.cmd.Process.Wait()
+	done = true
 
 	return
 }
@@ -885,6 +1069,7 @@ func (p *Profile) Wait() {
 
 	p.stateLock.Lock()
 	if !p.state {
+		p.stateLock.Unlock()
 		return
 	}
 	p.waiters = append(p.waiters, waiter)
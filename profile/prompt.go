@@ -0,0 +1,235 @@
+package profile
+
+import (
+	"../shared/events"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PromptAuth = "auth"
+	PromptKey  = "key_passphrase"
+
+	defaultPromptTimeout = 120 * time.Second
+)
+
+var (
+	sessionCreds = struct {
+		sync.RWMutex
+		m map[string]*Credentials
+	}{
+		m: map[string]*Credentials{},
+	}
+)
+
+type CredentialsData struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Timeout int    `json:"timeout"`
+}
+
+// Prompt timeout from credentialsPromptTimeout config in seconds
+func getPromptTimeout() time.Duration {
+	secs, err := strconv.Atoi(config.Local.Get("credentialsPromptTimeout"))
+	if err != nil || secs <= 0 {
+		return defaultPromptTimeout
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// Check if profile data asks for username and password
+func NeedsCredentials(data string) bool {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "auth-user-pass" {
+			return true
+		}
+	}
+	return false
+}
+
+func getSessionCredentials(id string) *Credentials {
+	sessionCreds.RLock()
+	defer sessionCreds.RUnlock()
+	return sessionCreds.m[id]
+}
+
+func setSessionCredentials(id, kind string, creds *Credentials) {
+	sessionCreds.Lock()
+	defer sessionCreds.Unlock()
+
+	cur := sessionCreds.m[id]
+	if cur == nil {
+		cur = &Credentials{}
+		sessionCreds.m[id] = cur
+	}
+
+	switch kind {
+	case PromptAuth:
+		cur.Username = creds.Username
+		cur.Password = creds.Password
+	case PromptKey:
+		cur.KeyPassphrase = creds.KeyPassphrase
+	}
+}
+
+func ClearSessionCredentials(id string) {
+	sessionCreds.Lock()
+	delete(sessionCreds.m, id)
+	sessionCreds.Unlock()
+}
+
+func clearSessionKeyPassphrase(id string) {
+	sessionCreds.Lock()
+	if cur := sessionCreds.m[id]; cur != nil {
+		cur.KeyPassphrase = ""
+	}
+	sessionCreds.Unlock()
+}
+
+// Use credentials remembered for this daemon session
+func (p *Profile) loadSessionCredentials(kind string) bool {
+	creds := getSessionCredentials(p.Id)
+	if creds == nil {
+		return false
+	}
+
+	switch kind {
+	case PromptAuth:
+		if creds.Username == "" && creds.Password == "" {
+			return false
+		}
+		p.Username = creds.Username
+		p.Password = creds.Password
+	case PromptKey:
+		if creds.KeyPassphrase == "" {
+			return false
+		}
+		p.KeyPassphrase = creds.KeyPassphrase
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (p *Profile) promptPending() bool {
+	p.challengeLock.Lock()
+	defer p.challengeLock.Unlock()
+	return p.credsPrompt != ""
+}
+
+// Hold connection until client provides credentials or prompt times out,
+// timer of previous prompt is replaced so it can not expire new prompt
+func (p *Profile) promptCredentials(kind string) {
+	timeout := getPromptTimeout()
+
+	p.challengeLock.Lock()
+	p.credsPrompt = kind
+	if p.credsTimer != nil {
+		p.credsTimer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		p.promptTimeout(timer)
+	})
+	p.credsTimer = timer
+	p.challengeLock.Unlock()
+
+	p.setState(AuthPending, "credentials_required")
+
+	evt := events.Event{
+		Type: "credentials_required",
+		Data: &CredentialsData{
+			Id:      p.Id,
+			Name:    p.Name,
+			Type:    kind,
+			Timeout: int(timeout / time.Second),
+		},
+	}
+	evt.Init()
+}
+
+func (p *Profile) promptTimeout(timer *time.Timer) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Panic("profile: Panic", err)
+		}
+	}()
+
+	p.challengeLock.Lock()
+	pending := p.credsTimer == timer
+	if pending {
+		p.credsPrompt = ""
+		p.credsTimer = nil
+	}
+	p.challengeLock.Unlock()
+
+	if !pending {
+		return
+	}
+
+	log.Warning("profile: Credentials prompt timeout", p.Id)
+
+	p.stop = true
+	p.setState(Failed, "credentials_timeout")
+	p.Stop()
+}
+
+// Answer credentials prompt, remembered credentials are kept in memory
+// until daemon exits
+func (p *Profile) ProvideCredentials(creds *Credentials,
+	remember bool) (err error) {
+
+	p.challengeLock.Lock()
+	kind := p.credsPrompt
+	p.credsPrompt = ""
+	if p.credsTimer != nil {
+		p.credsTimer.Stop()
+		p.credsTimer = nil
+	}
+	p.challengeLock.Unlock()
+
+	if kind == "" {
+		err = errors.New("profile: No pending credentials prompt")
+		return
+	}
+
+	switch kind {
+	case PromptAuth:
+		p.Username = creds.Username
+		p.Password = creds.Password
+	case PromptKey:
+		p.KeyPassphrase = creds.KeyPassphrase
+	}
+
+	if remember {
+		setSessionCredentials(p.Id, kind, creds)
+	}
+
+	p.setState(Connecting, "credentials_provided")
+
+	mgmt := p.mgmt
+	if mgmt == nil {
+		err = errors.New("profile: Management not connected")
+		return
+	}
+
+	switch kind {
+	case PromptAuth:
+		err = mgmt.sendAuth(p.Username, p.Password)
+	case PromptKey:
+		err = mgmt.sendKeyPassphrase(p.KeyPassphrase)
+	}
+	if err != nil {
+		return
+	}
+
+	return
+}
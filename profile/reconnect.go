@@ -0,0 +1,223 @@
+package profile
+
+import (
+	"../shared/events"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	TriggerExit    = "unexpected_exit"
+	TriggerPing    = "ping_timeout"
+	TriggerNetwork = "network_change"
+
+	defaultInitialBackoff = 2
+	defaultMaxBackoff     = 120
+	defaultJitter         = 0.2
+	reconnectSpacing      = 2 * time.Second
+)
+
+var (
+	reconnects = struct {
+		sync.Mutex
+		m    map[string]*Profile
+		last time.Time
+	}{
+		m: map[string]*Profile{},
+	}
+	validTriggers = map[string]bool{
+		TriggerExit:    true,
+		TriggerPing:    true,
+		TriggerNetwork: true,
+	}
+)
+
+// Reconnect policy, backoff is in seconds and jitter is fraction of
+// backoff. Zero max attempts is unlimited.
+type ReconnectPolicy struct {
+	Triggers       []string `json:"triggers"`
+	InitialBackoff int      `json:"initial_backoff"`
+	MaxBackoff     int      `json:"max_backoff"`
+	Jitter         float64  `json:"jitter"`
+	MaxAttempts    int      `json:"max_attempts"`
+}
+
+type ReconnectData struct {
+	Id          string    `json:"id"`
+	Trigger     string    `json:"trigger"`
+	Attempt     int       `json:"attempt"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		Triggers: []string{
+			TriggerExit,
+			TriggerPing,
+			TriggerNetwork,
+		},
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+		Jitter:         defaultJitter,
+	}
+}
+
+func (r *ReconnectPolicy) Validate() (err error) {
+	for _, trigger := range r.Triggers {
+		if !validTriggers[trigger] {
+			err = errors.New("profile: Invalid reconnect trigger " + trigger)
+			return
+		}
+	}
+
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = defaultInitialBackoff
+	}
+	if r.MaxBackoff < r.InitialBackoff {
+		r.MaxBackoff = r.InitialBackoff
+	}
+	if r.Jitter < 0 {
+		r.Jitter = 0
+	} else if r.Jitter > 1 {
+		r.Jitter = 1
+	}
+	if r.MaxAttempts < 0 {
+		r.MaxAttempts = 0
+	}
+
+	return
+}
+
+func (r *ReconnectPolicy) Has(trigger string) bool {
+	for _, t := range r.Triggers {
+		if t == trigger {
+			return true
+		}
+	}
+	return false
+}
+
+// Exponential backoff for attempt starting at one with jitter applied
+func (r *ReconnectPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(r.InitialBackoff)
+	for i := 1; i < attempt && backoff < float64(r.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if backoff > float64(r.MaxBackoff) {
+		backoff = float64(r.MaxBackoff)
+	}
+
+	backoff *= 1 + r.Jitter*(2*rand.Float64()-1)
+
+	return time.Duration(backoff * float64(time.Second))
+}
+
+func (p *Profile) getPolicy() *ReconnectPolicy {
+	if p.ReconnectPolicy != nil {
+		return p.ReconnectPolicy
+	}
+	return DefaultReconnectPolicy()
+}
+
+func (p *Profile) reconnectAllowed(trigger string) bool {
+	return p.Reconnect && p.getPolicy().Has(trigger)
+}
+
+// Reserve start time at least delay from now and spaced from other
+// reconnects so profiles don't restart in lockstep
+func reserveStart(delay time.Duration) (next time.Time) {
+	reconnects.Lock()
+	defer reconnects.Unlock()
+
+	next = time.Now().Add(delay)
+	if spaced := reconnects.last.Add(reconnectSpacing); next.Before(spaced) {
+		next = spaced
+	}
+	reconnects.last = next
+
+	return
+}
+
+// Schedule restart of profile after it exits according to policy
+func (p *Profile) scheduleReconnect(trigger string) {
+	if !p.reconnectAllowed(trigger) {
+		return
+	}
+
+	policy := p.getPolicy()
+	attempt := p.attempts + 1
+
+	if policy.MaxAttempts > 0 && attempt > policy.MaxAttempts {
+		log.Warning("profile: Reconnect attempts exhausted", p.Id)
+		return
+	}
+
+	prfl := p.Copy()
+	prfl.attempts = attempt
+
+	next := reserveStart(policy.Backoff(attempt))
+
+	reconnects.Lock()
+	reconnects.m[p.Id] = prfl
+	reconnects.Unlock()
+
+	evt := events.Event{
+		Type: "reconnect_scheduled",
+		Data: &ReconnectData{
+			Id:          p.Id,
+			Trigger:     trigger,
+			Attempt:     attempt,
+			NextAttempt: next,
+		},
+	}
+	evt.Init()
+
+	go func() {
+		defer func() {
+			err := recover()
+			if err != nil {
+				log.Panic("profile: Panic", err)
+			}
+		}()
+
+		p.Wait()
+		time.Sleep(time.Until(next))
+
+		reconnects.Lock()
+		cur := reconnects.m[prfl.Id]
+		if cur == prfl {
+			delete(reconnects.m, prfl.Id)
+		}
+		reconnects.Unlock()
+
+		if cur != prfl {
+			return
+		}
+
+		log.Info("profile: Reconnecting", prfl.Id, attempt)
+
+		err := prfl.Start(false)
+		if err != nil {
+			log.Error("profile: Restart error", err)
+			// failed start counts as attempt, next one is scheduled
+			prfl.scheduleReconnect(trigger)
+			return
+		}
+	}()
+}
+
+// Cancel pending reconnect of profile
+func CancelReconnect(id string) {
+	reconnects.Lock()
+	delete(reconnects.m, id)
+	reconnects.Unlock()
+}
+
+// Cancel all pending reconnects
+func CancelReconnects() {
+	reconnects.Lock()
+	reconnects.m = map[string]*Profile{}
+	reconnects.Unlock()
+}
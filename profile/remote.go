@@ -0,0 +1,390 @@
+package profile
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	probeTimeout     = 2 * time.Second
+	defaultPort      = "1194"
+	defaultProto     = "udp"
+	opHardResetV2    = 7
+	opHardResetSrvV2 = 8
+	staticKeySize    = 256
+)
+
+var (
+	digests = map[string]func() hash.Hash{
+		"MD5":    md5.New,
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA384": sha512.New384,
+		"SHA512": sha512.New,
+	}
+)
+
+// Result of remote latency probe, latency is in milliseconds
+type RemoteProbe struct {
+	Host    string  `json:"host"`
+	Port    string  `json:"port"`
+	Proto   string  `json:"proto"`
+	Latency float64 `json:"latency"`
+	Error   string  `json:"error,omitempty"`
+	line    int
+}
+
+type remoteConf struct {
+	remotes    []*RemoteProbe
+	tlsAuthKey []byte
+	keyDir     string
+	digest     string
+	tlsCrypt   bool
+	random     bool
+}
+
+func parseStaticKey(block string) (key []byte, err error) {
+	data := ""
+	inKey := false
+
+	for _, line := range strings.Split(block, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----BEGIN") {
+			inKey = true
+			continue
+		}
+		if strings.HasPrefix(line, "-----END") {
+			break
+		}
+		if inKey {
+			data += line
+		}
+	}
+
+	key, err = hex.DecodeString(data)
+	if err != nil {
+		err = errors.New("profile: Failed to decode static key " +
+			err.Error())
+		return
+	}
+
+	if len(key) != staticKeySize {
+		key = nil
+		err = errors.New("profile: Invalid static key size")
+		return
+	}
+
+	return
+}
+
+func parseRemoteConf(data string) (conf *remoteConf) {
+	conf = &remoteConf{
+		remotes: []*RemoteProbe{},
+		digest:  "SHA1",
+	}
+	port := defaultPort
+	proto := defaultProto
+
+	// remotes inside <connection> blocks are not probed
+	parsed, err := Parse(data)
+	if err != nil {
+		return
+	}
+
+	for _, block := range parsed.Blocks {
+		switch block.Name {
+		case "tls-auth":
+			key, err := parseStaticKey(block.Data)
+			if err != nil {
+				log.Warning("profile: Invalid tls-auth key", err)
+			} else {
+				conf.tlsAuthKey = key
+			}
+		case "tls-crypt", "tls-crypt-v2":
+			conf.tlsCrypt = true
+		}
+	}
+
+	for _, drct := range parsed.Directives {
+		args := drct.Args
+
+		switch drct.Name {
+		case "remote":
+			if len(args) < 1 {
+				continue
+			}
+			remote := &RemoteProbe{
+				Host: args[0],
+				line: drct.Line - 1,
+			}
+			if len(args) > 1 {
+				remote.Port = args[1]
+			}
+			if len(args) > 2 {
+				remote.Proto = args[2]
+			}
+			conf.remotes = append(conf.remotes, remote)
+		case "port":
+			if len(args) > 0 {
+				port = args[0]
+			}
+		case "proto":
+			if len(args) > 0 {
+				proto = args[0]
+			}
+		case "auth":
+			if len(args) > 0 {
+				conf.digest = strings.ToUpper(
+					strings.Replace(args[0], "-", "", -1))
+			}
+		case "key-direction":
+			if len(args) > 0 {
+				conf.keyDir = args[0]
+			}
+		case "tls-crypt", "tls-crypt-v2":
+			conf.tlsCrypt = true
+		case "remote-random":
+			conf.random = true
+		}
+	}
+
+	for _, remote := range conf.remotes {
+		if remote.Port == "" {
+			remote.Port = port
+		}
+		if remote.Proto == "" {
+			remote.Proto = proto
+		}
+	}
+
+	return
+}
+
+func isTcp(proto string) bool {
+	return strings.HasPrefix(proto, "tcp")
+}
+
+// Build P_CONTROL_HARD_RESET_CLIENT_V2 packet, signed with tls-auth key
+// when profile has one
+func (c *remoteConf) hardReset() (packet []byte, err error) {
+	sessionId := make([]byte, 8)
+	_, err = rand.Read(sessionId)
+	if err != nil {
+		err = errors.New("profile: Failed to generate session id " +
+			err.Error())
+		return
+	}
+
+	op := []byte{opHardResetV2 << 3}
+	// ack array length and message packet id
+	msg := []byte{0, 0, 0, 0, 0}
+
+	if c.tlsAuthKey == nil {
+		packet = append(packet, op...)
+		packet = append(packet, sessionId...)
+		packet = append(packet, msg...)
+		return
+	}
+
+	newHash, ok := digests[c.digest]
+	if !ok {
+		err = errors.New("profile: Unsupported tls-auth digest " + c.digest)
+		return
+	}
+
+	// outgoing hmac key is second half of key with key-direction 1
+	offset := 64
+	if c.keyDir == "1" {
+		offset = 192
+	}
+	mac := hmac.New(newHash, c.tlsAuthKey[offset:offset+newHash().Size()])
+
+	replay := make([]byte, 8)
+	binary.BigEndian.PutUint32(replay[0:4], 1)
+	binary.BigEndian.PutUint32(replay[4:8], uint32(time.Now().Unix()))
+
+	mac.Write(replay)
+	mac.Write(op)
+	mac.Write(sessionId)
+	mac.Write(msg)
+
+	packet = append(packet, op...)
+	packet = append(packet, sessionId...)
+	packet = append(packet, mac.Sum(nil)...)
+	packet = append(packet, replay...)
+	packet = append(packet, msg...)
+
+	return
+}
+
+func (c *remoteConf) probe(remote *RemoteProbe) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, remote.Host)
+	if err != nil || len(addrs) == 0 {
+		remote.Error = "resolve_failed"
+		return
+	}
+	addr := net.JoinHostPort(addrs[0], remote.Port)
+
+	if isTcp(remote.Proto) {
+		start := time.Now()
+
+		conn, e := net.DialTimeout("tcp", addr, probeTimeout)
+		if e != nil {
+			remote.Error = "connect_failed"
+			return
+		}
+		conn.Close()
+
+		remote.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+		return
+	}
+
+	if c.tlsCrypt {
+		remote.Error = "tls_crypt_unsupported"
+		return
+	}
+
+	packet, err := c.hardReset()
+	if err != nil {
+		remote.Error = "probe_failed"
+		return
+	}
+
+	conn, err := net.DialTimeout("udp", addr, probeTimeout)
+	if err != nil {
+		remote.Error = "connect_failed"
+		return
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(probeTimeout))
+
+	_, err = conn.Write(packet)
+	if err != nil {
+		remote.Error = "probe_failed"
+		return
+	}
+
+	resp := make([]byte, 1500)
+	n, err := conn.Read(resp)
+	if err != nil {
+		remote.Error = "timeout"
+		return
+	}
+
+	if n < 1 || resp[0]>>3 != opHardResetSrvV2 {
+		remote.Error = "invalid_response"
+		return
+	}
+
+	remote.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+}
+
+// Probe all remotes of profile data in parallel
+func ProbeRemotes(data string) (remotes []*RemoteProbe) {
+	return parseRemoteConf(data).probeAll()
+}
+
+func (c *remoteConf) probeAll() (remotes []*RemoteProbe) {
+	remotes = c.remotes
+
+	waiter := sync.WaitGroup{}
+	for _, remote := range remotes {
+		waiter.Add(1)
+
+		go func(remote *RemoteProbe) {
+			defer func() {
+				err := recover()
+				if err != nil {
+					log.Panic("profile: Panic", err)
+				}
+			}()
+			defer waiter.Done()
+
+			c.probe(remote)
+		}(remote)
+	}
+	waiter.Wait()
+
+	return
+}
+
+// Reorder remote lines so fastest remote is first, failed remotes keep
+// their relative order after reachable ones
+func orderRemotes(data string, remotes []*RemoteProbe) string {
+	if len(remotes) < 2 || parseRemoteConf(data).random {
+		return data
+	}
+
+	lines := strings.Split(data, "\n")
+	positions := []int{}
+	for _, remote := range remotes {
+		positions = append(positions, remote.line)
+	}
+
+	sorted := make([]*RemoteProbe, len(remotes))
+	copy(sorted, remotes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].Error == "") != (sorted[j].Error == "") {
+			return sorted[i].Error == ""
+		}
+		if sorted[i].Error != "" {
+			return false
+		}
+		return sorted[i].Latency < sorted[j].Latency
+	})
+
+	orig := make([]string, len(lines))
+	copy(orig, lines)
+
+	sort.Ints(positions)
+	for i, remote := range sorted {
+		lines[positions[i]] = orig[remote.line]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Probe remotes before connecting when profile has more than one
+func (p *Profile) probeRemotes() {
+	conf := parseRemoteConf(p.Data)
+	if len(conf.remotes) < 2 {
+		p.remotes = conf.remotes
+		return
+	}
+
+	p.remotes = conf.probeAll()
+
+	for _, remote := range p.remotes {
+		if remote.Error != "" {
+			log.Info("profile: Remote probe failed", p.Id,
+				remote.Host, remote.Port, remote.Error)
+		} else {
+			log.Info("profile: Remote probe", p.Id,
+				remote.Host, remote.Port, remote.Latency)
+		}
+	}
+}
+
+// Get remote probe results from last start
+func (p *Profile) GetRemotes() []*RemoteProbe {
+	return p.remotes
+}
@@ -0,0 +1,203 @@
+package profile
+
+import (
+	"../shared/utils"
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RoutePushed   = "pushed"
+	RouteRedirect = "redirect"
+	RouteInclude  = "include"
+	RouteExclude  = "exclude"
+)
+
+// Effective route of connected profile, network is in CIDR notation
+type Route struct {
+	Network string `json:"network"`
+	Gateway string `json:"gateway"`
+	Metric  int    `json:"metric,omitempty"`
+	Source  string `json:"source"`
+}
+
+// Network settings openvpn passes to route-up script
+type routeEnv struct {
+	dev        string
+	mtu        int
+	local      string
+	netmask    string
+	remote     string
+	local6     string
+	netbits6   int
+	vpnGateway string
+	netGateway string
+	trustedIp  string
+	redirect   bool
+	routes     []*Route
+}
+
+func (p *Profile) writeRouteUp() (pth string, err error) {
+	rootDir, err := utils.GetTempDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(rootDir, p.Id+"-route-up.sh")
+
+	err = ioutil.WriteFile(pth, []byte(routeUpScript), os.FileMode(0755))
+	if err != nil {
+		err = errors.New("profile: Failed to write route up script " +
+			err.Error())
+	}
+
+	return
+}
+
+func maskCidr(network, netmask string) string {
+	ip := net.ParseIP(network)
+	mask := net.ParseIP(netmask)
+	if ip == nil || mask == nil || ip.To4() == nil || mask.To4() == nil {
+		return ""
+	}
+
+	ones, _ := net.IPMask(mask.To4()).Size()
+	return ip.To4().Mask(net.IPMask(mask.To4())).String() + "/" +
+		strconv.Itoa(ones)
+}
+
+func parseRouteEnv(data string) (env *routeEnv) {
+	env = &routeEnv{
+		routes: []*Route{},
+	}
+	vals := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 {
+			vals[kv[0]] = kv[1]
+		}
+	}
+
+	env.dev = vals["dev"]
+	env.mtu, _ = strconv.Atoi(vals["tun_mtu"])
+	env.local = vals["ifconfig_local"]
+	env.netmask = vals["ifconfig_netmask"]
+	env.remote = vals["ifconfig_remote"]
+	env.local6 = vals["ifconfig_ipv6_local"]
+	env.netbits6, _ = strconv.Atoi(vals["ifconfig_ipv6_netbits"])
+	env.vpnGateway = vals["route_vpn_gateway"]
+	env.netGateway = vals["route_net_gateway"]
+	env.trustedIp = vals["trusted_ip"]
+	env.redirect = vals["redirect_gateway"] != "" &&
+		vals["redirect_gateway"] != "0"
+
+	for i := 1; ; i++ {
+		n := strconv.Itoa(i)
+		network, ok := vals["route_network_"+n]
+		if !ok {
+			break
+		}
+
+		cidr := maskCidr(network, vals["route_netmask_"+n])
+		if cidr == "" {
+			continue
+		}
+
+		route := &Route{
+			Network: cidr,
+			Gateway: vals["route_gateway_"+n],
+			Source:  RoutePushed,
+		}
+		route.Metric, _ = strconv.Atoi(vals["route_metric_"+n])
+		env.routes = append(env.routes, route)
+	}
+
+	for i := 1; ; i++ {
+		n := strconv.Itoa(i)
+		network, ok := vals["route_ipv6_network_"+n]
+		if !ok {
+			break
+		}
+
+		env.routes = append(env.routes, &Route{
+			Network: network,
+			Gateway: vals["route_ipv6_gateway_"+n],
+			Source:  RoutePushed,
+		})
+	}
+
+	if env.redirect {
+		for _, network := range []string{"0.0.0.0/1", "128.0.0.0/1"} {
+			env.routes = append(env.routes, &Route{
+				Network: network,
+				Gateway: env.vpnGateway,
+				Source:  RouteRedirect,
+			})
+		}
+	}
+
+	return
+}
+
+// Read settings written by route-up script, nil when script did not run
+func (p *Profile) readRouteEnv() (env *routeEnv) {
+	rootDir, err := utils.GetTempDir()
+	if err != nil {
+		return
+	}
+
+	pth := filepath.Join(rootDir, p.Id+"-route-up.sh.env")
+
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return
+	}
+	os.Remove(pth)
+
+	env = parseRouteEnv(string(data))
+	return
+}
+
+// Update effective routes after connect, include and exclude routes are
+// labeled by source and pushed routes are checked for local conflicts
+func (p *Profile) loadRoutes() {
+	env := p.readRouteEnv()
+	if env == nil {
+		return
+	}
+	p.routeEnv = env
+
+	sources := map[string]string{}
+	for _, ipNet := range p.includeNets {
+		sources[ipNet.String()] = RouteInclude
+	}
+	for _, ipNet := range p.excludeNets {
+		sources[ipNet.String()] = RouteExclude
+	}
+
+	for _, route := range env.routes {
+		if source, ok := sources[route.Network]; ok {
+			route.Source = source
+		}
+	}
+
+	p.checkRouteConflicts(env)
+
+	routes := append([]*Route{}, env.routes...)
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Source < routes[j].Source
+	})
+
+	p.Routes = routes
+	p.update()
+}
@@ -1,7 +1,14 @@
 package profile
 
 const (
-	blockScript    = "#!/bin/bash\n"
+	blockScript   = "#!/bin/bash\n"
+	routeUpScript = `#!/bin/bash
+
+# routes are read by daemon on connect
+umask 077
+env | grep -E '^(route_|ifconfig_|redirect_gateway=|dev=|tun_mtu=|trusted_ip)' > "$0.env"
+
+exit 0`
 	upScriptDarwin = `#!/bin/bash -e
 
 CONN_ID="$(echo ${config} | /sbin/md5)"
@@ -0,0 +1,136 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	domainRe = regexp.MustCompile(
+		`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
+)
+
+func splitEntry(entry string) (ipNet *net.IPNet, domain bool) {
+	entry = strings.TrimSpace(entry)
+
+	if _, n, err := net.ParseCIDR(entry); err == nil {
+		ipNet = n
+		return
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		ipNet = hostNet(ip)
+		return
+	}
+
+	domain = domainRe.MatchString(entry)
+	return
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{
+			IP:   ip4,
+			Mask: net.CIDRMask(32, 32),
+		}
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(128, 128),
+	}
+}
+
+// Validate split tunnel entries, entries are CIDRs, addresses or domains
+// resolved on connect
+func ValidateSplitRoutes(include, exclude []string) (err error) {
+	for _, entry := range include {
+		ipNet, domain := splitEntry(entry)
+		if ipNet == nil && !domain {
+			err = errors.New("profile: Invalid include route " + entry)
+			return
+		}
+	}
+
+	for _, entry := range exclude {
+		ipNet, domain := splitEntry(entry)
+		if ipNet == nil && !domain {
+			err = errors.New("profile: Invalid exclude route " + entry)
+			return
+		}
+		if ipNet != nil && ipNet.IP.To4() == nil {
+			err = errors.New("profile: IPv6 exclude route not supported " +
+				entry)
+			return
+		}
+	}
+
+	return
+}
+
+// Resolve split tunnel entries to networks, unresolved domains are skipped
+func resolveSplit(entries []string, ipv6 bool) (nets []*net.IPNet) {
+	nets = []*net.IPNet{}
+
+	for _, entry := range entries {
+		ipNet, domain := splitEntry(entry)
+		if ipNet != nil {
+			nets = append(nets, ipNet)
+			continue
+		}
+		if !domain {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			probeTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, entry)
+		cancel()
+		if err != nil {
+			log.Warning("profile: Failed to resolve split route", entry, err)
+			continue
+		}
+
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil || ipv6 {
+				nets = append(nets, hostNet(addr.IP))
+			}
+		}
+	}
+
+	return
+}
+
+// Launch arguments for split tunneling, include routes replace pushed
+// routes and exclude routes go through local gateway
+func (p *Profile) splitArgs() (args []string) {
+	args = []string{}
+	p.includeNets = resolveSplit(p.IncludeRoutes, true)
+	p.excludeNets = resolveSplit(p.ExcludeRoutes, false)
+
+	if len(p.IncludeRoutes) > 0 {
+		args = append(args,
+			"--pull-filter", "ignore", "redirect-gateway",
+			"--pull-filter", "ignore", "route ",
+			"--pull-filter", "ignore", "route-ipv6 ",
+		)
+
+		for _, ipNet := range p.includeNets {
+			if ipNet.IP.To4() != nil {
+				args = append(args, "--route", ipNet.IP.String(),
+					net.IP(ipNet.Mask).String())
+			} else {
+				args = append(args, "--route-ipv6", ipNet.String())
+			}
+		}
+	}
+
+	for _, ipNet := range p.excludeNets {
+		args = append(args, "--route", ipNet.IP.String(),
+			net.IP(ipNet.Mask).String(), "net_gateway")
+	}
+
+	return
+}
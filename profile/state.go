@@ -0,0 +1,129 @@
+package profile
+
+import (
+	"../shared/events"
+)
+
+type State string
+
+const (
+	Disconnected  State = "disconnected"
+	Connecting    State = "connecting"
+	AuthPending   State = "auth_pending"
+	Connected     State = "connected"
+	Reconnecting  State = "reconnecting"
+	Disconnecting State = "disconnecting"
+	Failed        State = "failed"
+)
+
+var (
+	transitions = map[State][]State{
+		Disconnected: {
+			Connecting,
+		},
+		Connecting: {
+			AuthPending,
+			Connected,
+			Reconnecting,
+			Disconnecting,
+			Disconnected,
+			Failed,
+		},
+		AuthPending: {
+			Connecting,
+			Connected,
+			Reconnecting,
+			Disconnecting,
+			Disconnected,
+			Failed,
+		},
+		Connected: {
+			Reconnecting,
+			Disconnecting,
+			Disconnected,
+			Failed,
+		},
+		Reconnecting: {
+			Connecting,
+			AuthPending,
+			Connected,
+			Disconnecting,
+			Disconnected,
+			Failed,
+		},
+		Disconnecting: {
+			Disconnected,
+			Failed,
+		},
+		Failed: {
+			Connecting,
+			Disconnecting,
+			Disconnected,
+		},
+	}
+)
+
+type StateChangedData struct {
+	Id    string `json:"id"`
+	Prev  State  `json:"prev"`
+	State State  `json:"state"`
+	Cause string `json:"cause"`
+}
+
+func (s State) CanTransition(state State) bool {
+	for _, next := range transitions[s] {
+		if next == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition profile to state, illegal transitions are rejected. Failed
+// state keeps cause as reason.
+func (p *Profile) setState(state State, cause string) (ok bool) {
+	p.statusLock.Lock()
+
+	prev := p.Status
+	if prev == "" {
+		prev = Disconnected
+	}
+
+	if prev == state {
+		p.statusLock.Unlock()
+		ok = true
+		return
+	}
+
+	if !prev.CanTransition(state) {
+		p.statusLock.Unlock()
+		log.Warning("profile: Illegal state transition",
+			p.Id, prev, state, cause)
+		return
+	}
+
+	p.Status = state
+	if state == Failed {
+		p.FailReason = cause
+	} else if state == Connecting {
+		p.FailReason = ""
+	}
+	p.statusLock.Unlock()
+
+	ok = true
+
+	evt := events.Event{
+		Type: "state_changed",
+		Data: &StateChangedData{
+			Id:    p.Id,
+			Prev:  prev,
+			State: state,
+			Cause: cause,
+		},
+	}
+	evt.Init()
+
+	p.update()
+
+	return
+}
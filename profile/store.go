@@ -0,0 +1,349 @@
+package profile
+
+import (
+	"../shared/secret"
+	"../shared/utils"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	storeLock sync.Mutex
+)
+
+// Profile persisted in data directory, credentials are sealed
+type StoredProfile struct {
+	Id              string           `json:"id"`
+	Name            string           `json:"name"`
+	Data            string           `json:"data"`
+	Reconnect       bool             `json:"reconnect"`
+	ReconnectPolicy *ReconnectPolicy `json:"reconnect_policy,omitempty"`
+	Timeouts        *ConnectTimeouts `json:"timeouts,omitempty"`
+	KillSwitch      bool             `json:"kill_switch"`
+	IncludeRoutes   []string         `json:"include_routes,omitempty"`
+	ExcludeRoutes   []string         `json:"exclude_routes,omitempty"`
+	RouteConflict   string           `json:"route_conflict,omitempty"`
+	CredentialStore string           `json:"credential_store,omitempty"`
+	Credentials     string           `json:"credentials,omitempty"`
+}
+
+type Credentials struct {
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	ServerPublicKey string `json:"server_public_key"`
+	KeyPassphrase   string `json:"key_passphrase,omitempty"`
+}
+
+func (s *StoredProfile) HasCredentials() bool {
+	return s.CredentialStore != ""
+}
+
+// Seal and set credentials, previous credentials are replaced
+func (s *StoredProfile) SetCredentials(creds *Credentials) (err error) {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		err = errors.New("profile: Failed to encode credentials " +
+			err.Error())
+		return
+	}
+
+	err = s.ForgetCredentials()
+	if err != nil {
+		return
+	}
+
+	store, sealed, err := secret.Seal(s.Id, data)
+	if err != nil {
+		return
+	}
+
+	s.CredentialStore = store
+	s.Credentials = sealed
+
+	return
+}
+
+// Seal credentials merged with existing, empty fields keep previous value
+func (s *StoredProfile) UpdateCredentials(creds *Credentials) (err error) {
+	merged, err := s.GetCredentials()
+	if err != nil {
+		return
+	}
+	if merged == nil {
+		merged = &Credentials{}
+	}
+
+	if creds.Username != "" {
+		merged.Username = creds.Username
+	}
+	if creds.Password != "" {
+		merged.Password = creds.Password
+	}
+	if creds.ServerPublicKey != "" {
+		merged.ServerPublicKey = creds.ServerPublicKey
+	}
+	if creds.KeyPassphrase != "" {
+		merged.KeyPassphrase = creds.KeyPassphrase
+	}
+
+	err = s.SetCredentials(merged)
+	return
+}
+
+// Unseal credentials, nil if profile has none
+func (s *StoredProfile) GetCredentials() (creds *Credentials, err error) {
+	if !s.HasCredentials() {
+		return
+	}
+
+	data, err := secret.Unseal(s.CredentialStore, s.Id, s.Credentials)
+	if err != nil {
+		return
+	}
+
+	creds = &Credentials{}
+	err = json.Unmarshal(data, creds)
+	if err != nil {
+		creds = nil
+		err = errors.New("profile: Failed to parse credentials " +
+			err.Error())
+		return
+	}
+
+	return
+}
+
+func (s *StoredProfile) ForgetCredentials() (err error) {
+	if !s.HasCredentials() {
+		return
+	}
+
+	err = secret.Forget(s.CredentialStore, s.Id)
+	if err != nil {
+		return
+	}
+
+	s.CredentialStore = ""
+	s.Credentials = ""
+
+	return
+}
+
+// Create profile for connecting, credentials are unsealed only here
+func (s *StoredProfile) NewProfile() (prfl *Profile, err error) {
+	creds, err := s.GetCredentials()
+	if err != nil {
+		return
+	}
+
+	prfl = &Profile{
+		Id:              s.Id,
+		Name:            s.Name,
+		Data:            s.Data,
+		Reconnect:       s.Reconnect,
+		ReconnectPolicy: s.ReconnectPolicy,
+		Timeouts:        s.Timeouts,
+		KillSwitch:      s.KillSwitch,
+		IncludeRoutes:   s.IncludeRoutes,
+		ExcludeRoutes:   s.ExcludeRoutes,
+		RouteConflict:   s.RouteConflict,
+	}
+
+	if creds != nil {
+		prfl.Username = creds.Username
+		prfl.Password = creds.Password
+		prfl.ServerPublicKey = creds.ServerPublicKey
+		prfl.KeyPassphrase = creds.KeyPassphrase
+	}
+
+	prfl.Init()
+
+	return
+}
+
+func getStoreDir() (pth string, err error) {
+	dataDir, err := utils.GetDataDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(dataDir, "profiles")
+
+	err = os.MkdirAll(pth, 0700)
+	if err != nil {
+		err = errors.New("profile: Failed to create store directory " +
+			err.Error())
+		return
+	}
+
+	return
+}
+
+func getStoredPath(id string) (pth string, err error) {
+	storeDir, err := getStoreDir()
+	if err != nil {
+		return
+	}
+
+	pth = filepath.Join(storeDir, id+".json")
+	return
+}
+
+func readStored(pth string) (stored *StoredProfile, err error) {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		err = errors.New("profile: Failed to read stored profile " +
+			err.Error())
+		return
+	}
+
+	stored = &StoredProfile{}
+	err = json.Unmarshal(data, stored)
+	if err != nil {
+		stored = nil
+		err = errors.New("profile: Failed to parse stored profile " +
+			err.Error())
+		return
+	}
+
+	return
+}
+
+// Get stored profile, nil if missing
+func GetStored(id string) (stored *StoredProfile, err error) {
+	id = FilterStr(id)
+	if id == "" {
+		return
+	}
+
+	pth, err := getStoredPath(id)
+	if err != nil {
+		return
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if _, e := os.Stat(pth); os.IsNotExist(e) {
+		return
+	}
+
+	stored, err = readStored(pth)
+	return
+}
+
+func GetStoredProfiles() (storeds []*StoredProfile, err error) {
+	storeds = []*StoredProfile{}
+
+	storeDir, err := getStoreDir()
+	if err != nil {
+		return
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	files, err := ioutil.ReadDir(storeDir)
+	if err != nil {
+		err = errors.New("profile: Failed to read store directory " +
+			err.Error())
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		stored, e := readStored(filepath.Join(storeDir, file.Name()))
+		if e != nil {
+			log.Error("profile: Failed to load stored profile",
+				file.Name(), e)
+			continue
+		}
+
+		storeds = append(storeds, stored)
+	}
+
+	sort.Slice(storeds, func(i, j int) bool {
+		return storeds[i].Id < storeds[j].Id
+	})
+
+	return
+}
+
+func SaveStored(stored *StoredProfile) (err error) {
+	stored.Id = FilterStr(stored.Id)
+	if stored.Id == "" {
+		err = errors.New("profile: Profile id required")
+		return
+	}
+
+	pth, err := getStoredPath(stored.Id)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		err = errors.New("profile: Failed to encode stored profile " +
+			err.Error())
+		return
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	tmpPth := pth + ".tmp"
+
+	err = ioutil.WriteFile(tmpPth, data, os.FileMode(0600))
+	if err != nil {
+		err = errors.New("profile: Failed to write stored profile " +
+			err.Error())
+		return
+	}
+
+	err = os.Rename(tmpPth, pth)
+	if err != nil {
+		os.Remove(tmpPth)
+		err = errors.New("profile: Failed to replace stored profile " +
+			err.Error())
+		return
+	}
+
+	return
+}
+
+func RemoveStored(id string) (err error) {
+	id = FilterStr(id)
+	if id == "" {
+		return
+	}
+
+	pth, err := getStoredPath(id)
+	if err != nil {
+		return
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	err = os.Remove(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+		err = errors.New("profile: Failed to remove stored profile " +
+			err.Error())
+		return
+	}
+
+	return
+}
@@ -0,0 +1,147 @@
+package profile
+
+import (
+	"../shared/events"
+	"time"
+)
+
+const (
+	StageResolve   = "resolve"
+	StageHandshake = "handshake"
+	StageAuth      = "auth"
+	StageConnect   = "connect"
+)
+
+var (
+	stageStates = map[string]string{
+		"RESOLVE":      StageResolve,
+		"TCP_CONNECT":  StageHandshake,
+		"WAIT":         StageHandshake,
+		"AUTH":         StageAuth,
+		"AUTH_PENDING": StageAuth,
+		"GET_CONFIG":   StageAuth,
+	}
+)
+
+// Connect timeouts in seconds, zero disables stage timeout. Stage timeouts
+// restart when openvpn enters stage so slow but progressing connections
+// are not interrupted.
+type ConnectTimeouts struct {
+	Resolve   int `json:"resolve"`
+	Handshake int `json:"handshake"`
+	Auth      int `json:"auth"`
+	Connect   int `json:"connect"`
+}
+
+type TimeoutData struct {
+	*Profile
+	Stage string `json:"stage"`
+}
+
+func (t *ConnectTimeouts) get(stage string) time.Duration {
+	secs := 0
+
+	switch stage {
+	case StageResolve:
+		secs = t.Resolve
+	case StageHandshake:
+		secs = t.Handshake
+	case StageAuth:
+		secs = t.Auth
+	case StageConnect:
+		secs = t.Connect
+	}
+
+	if secs < 0 {
+		secs = 0
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// Track stage from management state
+func (p *Profile) setStage(state string) {
+	stage := stageStates[state]
+
+	p.statusLock.Lock()
+	if stage != p.stage {
+		p.stage = stage
+		p.stageStart = time.Now()
+	}
+	p.statusLock.Unlock()
+}
+
+func (p *Profile) getStage() (stage string, start time.Time) {
+	p.statusLock.Lock()
+	stage = p.stage
+	start = p.stageStart
+	p.statusLock.Unlock()
+	return
+}
+
+// Stop connection when stage or overall connect timeout is exceeded
+func (p *Profile) watchTimeouts(timeouts *ConnectTimeouts, start time.Time) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Panic("profile: Panic", err)
+		}
+	}()
+
+	for {
+		time.Sleep(1 * time.Second)
+
+		switch p.Status {
+		case Connecting, AuthPending, Reconnecting:
+		default:
+			return
+		}
+
+		// time waiting on user input is not counted
+		if p.promptPending() {
+			start = start.Add(1 * time.Second)
+			continue
+		}
+
+		stage := ""
+
+		connTimeout := timeouts.get(StageConnect)
+		if connTimeout != 0 && time.Since(start) > connTimeout {
+			stage = StageConnect
+		} else {
+			curStage, stageStart := p.getStage()
+			stageTimeout := timeouts.get(curStage)
+
+			if curStage != "" && stageTimeout != 0 &&
+				time.Since(stageStart) > stageTimeout {
+
+				stage = curStage
+			}
+		}
+
+		if stage == "" {
+			continue
+		}
+
+		log.Warning("profile: Connect timeout", p.Id, stage)
+
+		p.stop = true
+		p.setState(Failed, "timeout_"+stage)
+
+		err := p.terminate(3 * time.Second)
+		if err != nil {
+			log.Error("profile: Timeout stop error", err)
+		}
+
+		evt := events.Event{
+			Type: "timeout_error",
+			Data: &TimeoutData{
+				Profile: p,
+				Stage:   stage,
+			},
+		}
+		evt.Init()
+
+		return
+	}
+}
@@ -81,7 +81,7 @@ func getOpenvpnDir() (pth string) {
 
 func GetStatus() (status bool) {
 	for _, prfl := range GetProfiles() {
-		if prfl.Status == "connected" {
+		if prfl.Status == Connected {
 			status = true
 		}
 	}
@@ -137,7 +137,9 @@ func RestartProfiles(resetNet bool) (err error) {
 	}
 
 	for _, prfl := range prfls2 {
-		if prfl.Reconnect {
+		if prfl.reconnectAllowed(TriggerNetwork) {
+			time.Sleep(time.Until(reserveStart(0)))
+
 			err = prfl.Start(false)
 			if err != nil {
 				return
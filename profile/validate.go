@@ -0,0 +1,398 @@
+package profile
+
+import (
+	"strconv"
+	"strings"
+)
+
+var (
+	// client directives accepted by openvpn 2.4 through 2.6
+	knownDirectives = map[string]bool{
+		"allow-compression":        true,
+		"allow-pull-fqdn":          true,
+		"allow-recursive-routing":  true,
+		"auth":                     true,
+		"auth-nocache":             true,
+		"auth-retry":               true,
+		"auth-token":               true,
+		"auth-token-user":          true,
+		"auth-user-pass":           true,
+		"auth-user-pass-optional":  true,
+		"bind":                     true,
+		"block-ipv6":               true,
+		"block-outside-dns":        true,
+		"ca":                       true,
+		"cert":                     true,
+		"cipher":                   true,
+		"client":                   true,
+		"client-cert-not-required": true,
+		"comp-lzo":                 true,
+		"compress":                 true,
+		"connect-retry":            true,
+		"connect-retry-max":        true,
+		"connect-timeout":          true,
+		"crl-verify":               true,
+		"data-ciphers":             true,
+		"data-ciphers-fallback":    true,
+		"dev":                      true,
+		"dev-node":                 true,
+		"dev-type":                 true,
+		"dh":                       true,
+		"dhcp-option":              true,
+		"disable-dco":              true,
+		"dns":                      true,
+		"down":                     true,
+		"down-pre":                 true,
+		"ecdh-curve":               true,
+		"explicit-exit-notify":     true,
+		"extra-certs":              true,
+		"fast-io":                  true,
+		"float":                    true,
+		"fragment":                 true,
+		"group":                    true,
+		"hand-window":              true,
+		"http-proxy":               true,
+		"http-proxy-option":        true,
+		"ifconfig":                 true,
+		"ifconfig-ipv6":            true,
+		"ifconfig-noexec":          true,
+		"ifconfig-pool-linear":     true,
+		"ignore-unknown-option":    true,
+		"inactive":                 true,
+		"ipchange":                 true,
+		"keepalive":                true,
+		"key":                      true,
+		"key-direction":            true,
+		"keysize":                  true,
+		"link-mtu":                 true,
+		"lport":                    true,
+		"machine-readable-output":  true,
+		"management-external-cert": true,
+		"management-external-key":  true,
+		"max-routes":               true,
+		"mssfix":                   true,
+		"mute":                     true,
+		"mute-replay-warnings":     true,
+		"ncp-ciphers":              true,
+		"ncp-disable":              true,
+		"no-iv":                    true,
+		"no-replay":                true,
+		"nobind":                   true,
+		"ns-cert-type":             true,
+		"peer-fingerprint":         true,
+		"persist-key":              true,
+		"persist-local-ip":         true,
+		"persist-remote-ip":        true,
+		"persist-tun":              true,
+		"ping":                     true,
+		"ping-exit":                true,
+		"ping-restart":             true,
+		"pkcs12":                   true,
+		"plugin":                   true,
+		"port":                     true,
+		"proto":                    true,
+		"providers":                true,
+		"pull":                     true,
+		"pull-filter":              true,
+		"push-peer-info":           true,
+		"rcvbuf":                   true,
+		"redirect-gateway":         true,
+		"redirect-private":         true,
+		"register-dns":             true,
+		"remote":                   true,
+		"remote-cert-eku":          true,
+		"remote-cert-ku":           true,
+		"remote-cert-tls":          true,
+		"remote-random":            true,
+		"remote-random-hostname":   true,
+		"reneg-bytes":              true,
+		"reneg-pkts":               true,
+		"reneg-sec":                true,
+		"replay-window":            true,
+		"resolv-retry":             true,
+		"route":                    true,
+		"route-delay":              true,
+		"route-gateway":            true,
+		"route-ipv6":               true,
+		"route-method":             true,
+		"route-metric":             true,
+		"route-noexec":             true,
+		"route-nopull":             true,
+		"route-pre-down":           true,
+		"route-up":                 true,
+		"route-up-delay":           true,
+		"rport":                    true,
+		"script-security":          true,
+		"server-poll-timeout":      true,
+		"setenv":                   true,
+		"setenv-safe":              true,
+		"sndbuf":                   true,
+		"socks-proxy":              true,
+		"static-challenge":         true,
+		"tls-auth":                 true,
+		"tls-cert-profile":         true,
+		"tls-cipher":               true,
+		"tls-ciphersuites":         true,
+		"tls-client":               true,
+		"tls-crypt":                true,
+		"tls-crypt-v2":             true,
+		"tls-remote":               true,
+		"tls-timeout":              true,
+		"tls-verify":               true,
+		"tls-version-max":          true,
+		"tls-version-min":          true,
+		"topology":                 true,
+		"tun-ipv6":                 true,
+		"tun-mtu":                  true,
+		"tun-mtu-extra":            true,
+		"txqueuelen":               true,
+		"up":                       true,
+		"up-delay":                 true,
+		"up-restart":               true,
+		"user":                     true,
+		"verb":                     true,
+		"verify-x509-name":         true,
+		"win-sys":                  true,
+		"windows-driver":           true,
+	}
+	// directives removed or deprecated in openvpn 2.5 and later
+	deprecatedDirectives = map[string]string{
+		"client-cert-not-required": "removed in openvpn 2.5",
+		"comp-lzo":                 "use compress or remove compression",
+		"ifconfig-pool-linear":     "removed in openvpn 2.5",
+		"keysize":                  "removed in openvpn 2.6",
+		"max-routes":               "removed in openvpn 2.4",
+		"ncp-ciphers":              "use data-ciphers",
+		"ncp-disable":              "removed in openvpn 2.6",
+		"no-iv":                    "removed in openvpn 2.5",
+		"no-replay":                "removed in openvpn 2.6",
+		"ns-cert-type":             "use remote-cert-tls",
+		"tls-remote":               "removed in openvpn 2.4",
+		"tun-ipv6":                 "ignored since openvpn 2.4",
+	}
+	remoteProtos = map[string]bool{
+		"udp":         true,
+		"udp4":        true,
+		"udp6":        true,
+		"tcp":         true,
+		"tcp4":        true,
+		"tcp6":        true,
+		"tcp-client":  true,
+		"tcp4-client": true,
+		"tcp6-client": true,
+	}
+)
+
+// Problem found in profile, line 0 applies to whole profile
+type Issue struct {
+	Line      int    `json:"line"`
+	Directive string `json:"directive,omitempty"`
+	Message   string `json:"message"`
+}
+
+type Validation struct {
+	Valid    bool      `json:"valid"`
+	Errors   []*Issue  `json:"errors"`
+	Warnings []*Issue  `json:"warnings"`
+	Changes  []*Change `json:"changes"`
+}
+
+func (v *Validation) error(line int, drct, msg string) {
+	v.Errors = append(v.Errors, &Issue{
+		Line:      line,
+		Directive: drct,
+		Message:   msg,
+	})
+}
+
+func (v *Validation) warning(line int, drct, msg string) {
+	v.Warnings = append(v.Warnings, &Issue{
+		Line:      line,
+		Directive: drct,
+		Message:   msg,
+	})
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// Type of tun device from dev name such as tun0
+func devType(dev string) string {
+	for _, typ := range []string{"tun", "tap"} {
+		if strings.HasPrefix(dev, typ) {
+			return typ
+		}
+	}
+	return ""
+}
+
+// Validate profile data without starting openvpn
+func Validate(data string) (valid *Validation) {
+	valid = &Validation{
+		Errors:   []*Issue{},
+		Warnings: []*Issue{},
+		Changes:  []*Change{},
+	}
+
+	conf, err := Parse(data)
+	if err != nil {
+		if e, ok := err.(*ParseError); ok {
+			valid.error(e.Line, "", e.Message)
+		} else {
+			valid.error(0, "", err.Error())
+		}
+		return
+	}
+
+	policy := getPolicy()
+	denied := map[int]bool{}
+
+	drcts, err := policyDirectives(conf)
+	if err != nil {
+		if e, ok := err.(*ParseError); ok {
+			valid.error(e.Line, "", e.Message)
+		} else {
+			valid.error(0, "", err.Error())
+		}
+		return
+	}
+
+	for _, drct := range drcts {
+		e := policy.check(drct)
+		if e == nil {
+			continue
+		}
+
+		if e.File != "" {
+			valid.error(drct.Line, drct.Name,
+				"file "+e.File+" not allowed by policy")
+		} else {
+			valid.error(drct.Line, drct.Name, "not allowed by policy")
+		}
+		denied[drct.Line] = true
+	}
+
+	for _, drct := range conf.Directives {
+		if denied[drct.Line] {
+			continue
+		}
+
+		if !knownDirectives[drct.Name] {
+			if !conf.Ignored[drct.Name] {
+				valid.warning(drct.Line, drct.Name, "unknown directive")
+			}
+			continue
+		}
+
+		if msg, ok := deprecatedDirectives[drct.Name]; ok {
+			valid.warning(drct.Line, drct.Name, "deprecated, "+msg)
+		}
+
+		if fileDirectives[drct.Name] && len(drct.Args) > 0 &&
+			drct.Args[0] != "[inline]" {
+
+			valid.error(drct.Line, drct.Name,
+				"references external file "+drct.Args[0])
+		}
+	}
+
+	if !conf.Has("ca") && conf.Block("ca") == nil &&
+		!conf.Has("pkcs12") && conf.Block("pkcs12") == nil {
+
+		valid.error(0, "ca", "missing <ca>")
+	}
+
+	if block := conf.Block("key"); block != nil &&
+		keyBlockEncrypted(block.Data) {
+
+		valid.warning(block.Line, "key",
+			"private key is encrypted, passphrase required")
+	}
+
+	validateDev(conf, valid)
+	validateRemotes(conf, valid)
+
+	valid.Changes = cipherChanges(conf)
+
+	valid.Valid = len(valid.Errors) == 0
+
+	return
+}
+
+func validateDev(conf *Config, valid *Validation) {
+	typ := ""
+	typLine := 0
+
+	for _, name := range []string{"dev", "dev-type"} {
+		for _, drct := range conf.Get(name) {
+			if len(drct.Args) == 0 {
+				valid.error(drct.Line, drct.Name, "missing device")
+				continue
+			}
+
+			drctTyp := devType(drct.Args[0])
+			if drctTyp == "" {
+				if drct.Name == "dev-type" {
+					valid.error(drct.Line, drct.Name,
+						"unknown device type "+drct.Args[0])
+				}
+				continue
+			}
+
+			if typ != "" && typ != drctTyp {
+				valid.error(drct.Line, drct.Name, "conflicts with "+typ+
+					" device on line "+strconv.Itoa(typLine))
+				continue
+			}
+
+			typ = drctTyp
+			typLine = drct.Line
+		}
+	}
+
+	if typ == "" {
+		valid.error(0, "dev", "missing dev")
+	}
+}
+
+func validateRemotes(conf *Config, valid *Validation) {
+	remotes := conf.Get("remote")
+
+	if len(remotes) == 0 && conf.Block("connection") == nil {
+		valid.error(0, "remote", "missing remote")
+	}
+
+	for _, drct := range remotes {
+		if len(drct.Args) == 0 {
+			valid.error(drct.Line, drct.Name, "missing host")
+			continue
+		}
+
+		if len(drct.Args) > 1 && !validPort(drct.Args[1]) {
+			valid.error(drct.Line, drct.Name, "invalid port "+drct.Args[1])
+		}
+
+		if len(drct.Args) > 2 && !remoteProtos[drct.Args[2]] {
+			valid.error(drct.Line, drct.Name,
+				"invalid protocol "+drct.Args[2])
+		}
+
+		if len(drct.Args) > 3 {
+			valid.warning(drct.Line, drct.Name, "extra arguments")
+		}
+	}
+
+	for _, drct := range conf.Get("port") {
+		if len(drct.Args) == 0 || !validPort(drct.Args[0]) {
+			valid.error(drct.Line, drct.Name, "invalid port")
+		}
+	}
+
+	for _, drct := range conf.Get("proto") {
+		if len(drct.Args) == 0 || !remoteProtos[drct.Args[0]] {
+			valid.error(drct.Line, drct.Name, "invalid protocol")
+		}
+	}
+}
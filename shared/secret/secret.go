@@ -0,0 +1,179 @@
+// Credentials sealing with daemon master key or Secret Service.
+package secret
+
+import (
+	"../utils"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"github.com/AlexeySpiridonov/goapp-config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+const (
+	StoreMaster  = "master"
+	StoreService = "secret-service"
+	keySize      = 32
+)
+
+var (
+	masterKey  []byte
+	masterLock sync.Mutex
+)
+
+// Get store from credentialStore config, Secret Service only on linux
+func GetStore() string {
+	if runtime.GOOS == "linux" &&
+		config.Local.Get("credentialStore") == StoreService {
+
+		return StoreService
+	}
+	return StoreMaster
+}
+
+func getMasterKey() (key []byte, err error) {
+	masterLock.Lock()
+	defer masterLock.Unlock()
+
+	if masterKey != nil {
+		key = masterKey
+		return
+	}
+
+	dataDir, err := utils.GetDataDir()
+	if err != nil {
+		return
+	}
+
+	pth := filepath.Join(dataDir, "master.key")
+
+	key, err = ioutil.ReadFile(pth)
+	if err == nil {
+		// replacing key would lose all sealed credentials
+		if len(key) != keySize {
+			err = errors.New("secret: Invalid master key length " +
+				strconv.Itoa(len(key)) + " in " + pth)
+			key = nil
+			return
+		}
+
+		masterKey = key
+		return
+	}
+
+	if !os.IsNotExist(err) {
+		err = errors.New("secret: Failed to read master key " + err.Error())
+		key = nil
+		return
+	}
+
+	key, err = utils.RandBytes(keySize)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(pth, key, os.FileMode(0600))
+	if err != nil {
+		err = errors.New("secret: Failed to write master key " + err.Error())
+		key = nil
+		return
+	}
+
+	masterKey = key
+	return
+}
+
+func getAead() (aead cipher.AEAD, err error) {
+	key, err := getMasterKey()
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		err = errors.New("secret: Failed to init cipher " + err.Error())
+		return
+	}
+
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		err = errors.New("secret: Failed to init aead " + err.Error())
+		return
+	}
+
+	return
+}
+
+// Seal data for id. With master key returns ciphertext, with Secret
+// Service data is stored externally and sealed is empty.
+func Seal(id string, data []byte) (store, sealed string, err error) {
+	store = GetStore()
+
+	if store == StoreService {
+		err = serviceStore(id, data)
+		return
+	}
+
+	aead, err := getAead()
+	if err != nil {
+		return
+	}
+
+	nonce, err := utils.RandBytes(aead.NonceSize())
+	if err != nil {
+		return
+	}
+
+	ciphertext := aead.Seal(nonce, nonce, data, []byte(id))
+	sealed = base64.StdEncoding.EncodeToString(ciphertext)
+
+	return
+}
+
+func Unseal(store, id, sealed string) (data []byte, err error) {
+	if store == StoreService {
+		data, err = serviceLookup(id)
+		return
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		err = errors.New("secret: Failed to decode sealed data " + err.Error())
+		return
+	}
+
+	aead, err := getAead()
+	if err != nil {
+		return
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		err = errors.New("secret: Sealed data too short")
+		return
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	ciphertext = ciphertext[aead.NonceSize():]
+
+	data, err = aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		err = errors.New("secret: Failed to open sealed data " + err.Error())
+		return
+	}
+
+	return
+}
+
+// Remove externally stored data, no-op for master key
+func Forget(store, id string) (err error) {
+	if store == StoreService {
+		err = serviceClear(id)
+	}
+	return
+}
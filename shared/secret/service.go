@@ -0,0 +1,48 @@
+package secret
+
+import (
+	"../utils"
+	"errors"
+)
+
+const (
+	serviceAttr = "pritunl-profile"
+)
+
+// Secret Service access through libsecret secret-tool
+func serviceStore(id string, data []byte) (err error) {
+	err = utils.ExecInput(string(data), "secret-tool", "store",
+		"--label=Pritunl profile "+id, serviceAttr, id)
+	if err != nil {
+		err = errors.New("secret: Failed to store secret " + err.Error())
+		return
+	}
+
+	return
+}
+
+func serviceLookup(id string) (data []byte, err error) {
+	output, err := utils.ExecOutput("secret-tool", "lookup", serviceAttr, id)
+	if err != nil {
+		err = errors.New("secret: Failed to lookup secret " + err.Error())
+		return
+	}
+
+	if output == "" {
+		err = errors.New("secret: Secret not found " + id)
+		return
+	}
+
+	data = []byte(output)
+	return
+}
+
+func serviceClear(id string) (err error) {
+	err = utils.Exec("secret-tool", "clear", serviceAttr, id)
+	if err != nil {
+		err = errors.New("secret: Failed to clear secret " + err.Error())
+		return
+	}
+
+	return
+}
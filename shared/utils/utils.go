@@ -742,6 +742,37 @@ func GetTempDir() (pth string, err error) {
 	return
 }
 
+func GetDataDir() (pth string, err error) {
+	if config.Local.Name == "dev" {
+		pth = filepath.Join(GetRootDir(), "..", "dev", "data")
+		err = os.MkdirAll(pth, 0700)
+		return
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		pth = filepath.Join("C:\\", "ProgramData", "Pritunl", "data")
+		break
+	case "darwin":
+		pth = filepath.Join(string(os.PathSeparator), "Applications",
+			"Pritunl.app", "Contents", "Resources", "data")
+		break
+	case "linux":
+		pth = filepath.Join(string(filepath.Separator),
+			"var", "lib", "pritunl")
+		break
+	default:
+		log.Panic("profile: Not implemented")
+	}
+
+	err = os.MkdirAll(pth, 0700)
+	if err != nil {
+		err = errors.New("utils: Failed to create data directory " + err.Error())
+	}
+
+	return
+}
+
 func GetPidPath() (pth string) {
 	if config.Local.Name == "dev" {
 		pth = filepath.Join(GetRootDir(), "..", "dev")
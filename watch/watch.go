@@ -202,8 +202,23 @@ func dnsWatch() {
 	}
 }
 
+func certWatch() {
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Panic("watch: Panic", err)
+		}
+	}()
+
+	for {
+		profile.CheckAllCertificates()
+		time.Sleep(12 * time.Hour)
+	}
+}
+
 func StartWatch() {
 	go wakeWatch(10 * time.Millisecond)
 	go wakeWatch(100 * time.Millisecond)
 	go dnsWatch()
+	go certWatch()
 }